@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminConfig describes the admin API, which is disabled unless a token
+// is set.
+type adminConfig struct {
+	// Addr is the address of a separate listener for the admin API, if
+	// empty it's served by the webhook server.
+	Addr string `yaml:"addr"`
+	// Prefix is the path prefix of the admin endpoints, defaulting to
+	// "/admin".
+	Prefix string `yaml:"prefix"`
+	// Token must be provided as a bearer token by admin requests.
+	Token string `yaml:"token"`
+}
+
+// pullView is the JSON representation of a tracked pull.
+type pullView struct {
+	Number         int               `json:"number"`
+	Head           string            `json:"head"`
+	Author         string            `json:"author"`
+	Base           string            `json:"base"`
+	Approvals      map[string]int    `json:"approvals"`
+	Labels         []string          `json:"labels"`
+	Assigned       []string          `json:"assigned"`
+	Delegates      map[string]string `json:"delegates"`
+	Opened         time.Time         `json:"opened"`
+	Pushed         time.Time         `json:"pushed"`
+	CarriedFrom    string            `json:"carried-from,omitempty"`
+	OverrideBy     string            `json:"override-by,omitempty"`
+	OverrideReason string            `json:"override-reason,omitempty"`
+	Evaluation     evaluationView    `json:"evaluation"`
+	Posted         evaluationView    `json:"posted"`
+	Queue          queueView         `json:"queue"`
+}
+
+type evaluationView struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+}
+
+type queueView struct {
+	Priority    int    `json:"priority"`
+	Rollup      bool   `json:"rollup"`
+	Batch       int    `json:"batch,omitempty"`
+	TestMerge   string `json:"test-merge,omitempty"`
+	MergeFailed string `json:"merge-failed,omitempty"`
+}
+
+// view returns the JSON representation of a pull, including a fresh
+// evaluation of the review policy. rp.pMu must be held by the caller.
+func (rp *rplus) view(pr int, o *pull) pullView {
+	state, desc := rp.evaluate(o)
+	approvals := make(map[string]int, len(o.approvals))
+	for r, id := range o.approvals {
+		approvals[r] = id
+	}
+	delegates := make(map[string]string, len(o.delegates))
+	for u, r := range o.delegates {
+		delegates[u] = r
+	}
+	return pullView{
+		Number:         pr,
+		Head:           o.currentHash,
+		Author:         o.author,
+		Base:           o.base,
+		Approvals:      approvals,
+		Labels:         sortedSet(o.labels),
+		Assigned:       append([]string{}, o.assigned...),
+		Delegates:      delegates,
+		Opened:         o.opened,
+		Pushed:         o.pushed,
+		CarriedFrom:    o.carriedFrom,
+		OverrideBy:     o.overrideBy,
+		OverrideReason: o.overrideReason,
+		Evaluation:     evaluationView{state, desc},
+		Posted:         evaluationView{o.state, o.desc},
+		Queue: queueView{
+			Priority:    o.priority,
+			Rollup:      o.rollup,
+			Batch:       o.batch,
+			TestMerge:   o.testMerge,
+			MergeFailed: o.mergeFailed,
+		},
+	}
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	list := setToList(set)
+	sort.Strings(list)
+	return list
+}
+
+// configView is the JSON representation of the effective configuration,
+// excluding secrets.
+type configView struct {
+	Repo               string               `json:"repo"`
+	Reviewers          []string             `json:"reviewers"`
+	RequiredReviews    int                  `json:"required-reviews"`
+	Groups             map[string][]string  `json:"groups"`
+	Labels             map[string]labelRule `json:"labels"`
+	ReviewPattern      string               `json:"review-pattern"`
+	BotName            string               `json:"bot-name"`
+	SelfReview         bool                 `json:"self-review"`
+	CountEdited        bool                 `json:"count-edited-comments"`
+	BotComment         bool                 `json:"bot-comment"`
+	Templates          []string             `json:"templates"`
+	CheckCollaborators bool                 `json:"check-collaborators"`
+	CollaboratorTTL    string               `json:"collaborator-cache-ttl"`
+	Overriders         []string             `json:"overriders"`
+	OverrideIssues     bool                 `json:"override-issues"`
+	AuditLog           string               `json:"audit-log"`
+	CarryApprovals     bool                 `json:"carry-approvals"`
+	MinOpenDuration    string               `json:"min-open-duration"`
+	SkipWeekends       bool                 `json:"min-open-skip-weekends"`
+	StateFile          string               `json:"state-file"`
+	Assign             assignConfig         `json:"assign"`
+	RemindAfter        string               `json:"remind-after"`
+	RemindInterval     string               `json:"remind-interval"`
+	DigestIssue        int                  `json:"digest-issue"`
+	DigestHour         int                  `json:"digest-hour"`
+	MergeQueue         queueConfig          `json:"merge-queue"`
+	QueueInterval      string               `json:"merge-queue-interval"`
+	Dashboard          dashboardConfig      `json:"dashboard"`
+}
+
+// configView returns the effective configuration.
+func (rp *rplus) configView() configView {
+	groups := make(map[string][]string, len(rp.groups))
+	for g, members := range rp.groups {
+		groups[g] = sortedSet(members)
+	}
+	templates := make([]string, 0, len(rp.templates))
+	for name := range rp.templates {
+		templates = append(templates, name)
+	}
+	sort.Strings(templates)
+	pattern := ""
+	if rp.reviewPattern != nil {
+		pattern = rp.reviewPattern.String()
+	}
+	return configView{
+		Repo:               rp.repo,
+		Reviewers:          sortedSet(rp.reviewers),
+		RequiredReviews:    rp.requiredReviews,
+		Groups:             groups,
+		Labels:             rp.labelRules,
+		ReviewPattern:      pattern,
+		BotName:            rp.botName,
+		SelfReview:         rp.selfReview,
+		CountEdited:        rp.countEdited,
+		BotComment:         rp.botComments,
+		Templates:          templates,
+		CheckCollaborators: rp.checkCollaborators,
+		CollaboratorTTL:    rp.collaboratorTTL.String(),
+		Overriders:         sortedSet(rp.overriders),
+		OverrideIssues:     rp.overrideIssues,
+		AuditLog:           rp.auditLog,
+		CarryApprovals:     rp.carryApprovals,
+		MinOpenDuration:    rp.minOpen.String(),
+		SkipWeekends:       rp.skipWeekends,
+		StateFile:          rp.stateFile,
+		Assign:             rp.assign,
+		RemindAfter:        rp.remindAfter.String(),
+		RemindInterval:     rp.remindInterval.String(),
+		DigestIssue:        rp.digestIssue,
+		DigestHour:         rp.digestHour,
+		MergeQueue:         rp.queue,
+		QueueInterval:      rp.queueInterval.String(),
+		Dashboard:          rp.dashboard,
+	}
+}
+
+// authorized returns true if the request carries the admin token.
+func (rp *rplus) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if rp.admin.Token == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(rp.admin.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// adminAction is the body of admin requests which change state.
+type adminAction struct {
+	// User is the operator making the change, recorded in the logs and
+	// audit log.
+	User        string `json:"user"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Reason      string `json:"reason"`
+}
+
+// sortedPulls returns the numbers of the tracked pulls in order. rp.pMu
+// must be held by the caller.
+func (rp *rplus) sortedPulls() []int {
+	prs := make([]int, 0, len(rp.pending))
+	for pr := range rp.pending {
+		prs = append(prs, pr)
+	}
+	sort.Ints(prs)
+	return prs
+}
+
+// adminHandler serves the admin API:
+//
+//	GET  <prefix>/pulls             lists the tracked pulls
+//	GET  <prefix>/pulls/<n>         returns a single pull
+//	GET  <prefix>/config            returns the effective configuration
+//	POST <prefix>/pulls/<n>/resync  re-syncs a pull from GitHub
+//	POST <prefix>/pulls/<n>/clear   clears the approvals of a pull
+//	POST <prefix>/pulls/<n>/status  sets the status of a pull
+//	POST <prefix>/repost            re-posts the status of every pull
+func (rp *rplus) adminHandler(w http.ResponseWriter, r *http.Request) {
+	rp.confMu.RLock()
+	defer rp.confMu.RUnlock()
+	l := rp.log.with("path", r.URL.Path)
+	if !rp.authorized(r) {
+		l.warn("unauthorized admin request")
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, rp.adminPrefix()), "/"), "/")
+	route, method := "", "GET"
+	pr := 0
+	switch {
+	case len(parts) == 1 && (parts[0] == "config" || parts[0] == "pulls"):
+		route = parts[0]
+	case len(parts) == 1 && parts[0] == "repost":
+		route, method = "repost", "POST"
+	case (len(parts) == 2 || len(parts) == 3) && parts[0] == "pulls":
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pull request number")
+			return
+		}
+		pr, route = n, "pull"
+		if len(parts) == 3 {
+			route, method = parts[2], "POST"
+		}
+	}
+	switch route {
+	case "config", "pulls", "pull", "repost", "resync", "clear", "status":
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var action adminAction
+	if method == "POST" && r.Body != nil && r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&action)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	if method == "POST" {
+		l.info("admin request", "pr", pr, "user", action.User)
+	}
+
+	switch route {
+	case "config":
+		writeJSON(w, http.StatusOK, rp.configView())
+	case "pulls":
+		rp.pMu.Lock()
+		defer rp.pMu.Unlock()
+		pulls := []pullView{}
+		for _, pr := range rp.sortedPulls() {
+			pulls = append(pulls, rp.view(pr, rp.pending[pr]))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"repo": rp.repo, "pulls": pulls})
+	case "pull":
+		rp.writePull(w, pr)
+	case "repost":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"failed": rp.repostAll()})
+	case "resync":
+		err := rp.resync(pr)
+		if err != nil {
+			l.error("failed to re-sync pull", "pr", pr, "err", err)
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		rp.writePull(w, pr)
+	case "clear":
+		err := rp.clearApprovals(pr)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		rp.writePull(w, pr)
+	case "status":
+		if action.User == "" {
+			writeError(w, http.StatusBadRequest, "a user is required")
+			return
+		}
+		err := rp.setStatus(pr, action.User, action.State, action.Description, action.Reason)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rp.writePull(w, pr)
+	}
+}
+
+// writePull writes the JSON representation of a pull, or an error if it
+// isn't tracked.
+func (rp *rplus) writePull(w http.ResponseWriter, pr int) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		writeError(w, http.StatusNotFound, "unknown pull request")
+		return
+	}
+	writeJSON(w, http.StatusOK, rp.view(pr, o))
+}
+
+// serveAdmin registers the admin API, either on the webhook server's mux
+// or on its own listener, which uses the webhook server's certificate.
+func (rp *rplus) serveAdmin(webhookMux *http.ServeMux, certPath, keyPath string) {
+	if rp.admin.Token == "" {
+		return
+	}
+	if rp.admin.Addr == "" {
+		webhookMux.HandleFunc(rp.adminPrefix()+"/", rp.adminHandler)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(rp.adminPrefix()+"/", rp.adminHandler)
+	go func() {
+		err := rp.serve(&http.Server{Addr: rp.admin.Addr, Handler: mux}, certPath, keyPath)
+		if err != nil {
+			rp.log.error("admin listener failed", "addr", rp.admin.Addr, "err", err)
+		}
+	}()
+}
+
+func (rp *rplus) adminPrefix() string {
+	if rp.admin.Prefix == "" {
+		return "/admin"
+	}
+	return strings.TrimSuffix(rp.admin.Prefix, "/")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(rp *rplus, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	rp.adminHandler(rec, req)
+	return rec
+}
+
+func TestAdminHandler(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		requiredReviews: 2,
+		secret:          []byte("webhook-secret"),
+		admin:           adminConfig{Token: "admin-token"},
+		collaboratorTTL: 10 * time.Minute,
+	}
+	rp.newCommit(nil, 2, "other-hash", "roland", "master", nil)
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "alice", "", 10)
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := adminRequest(rp, "GET", "/admin/pulls", token); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("adminHandler returned %d for token %q", rec.Code, token)
+		}
+	}
+	if rec := adminRequest(rp, "POST", "/admin/pulls", "admin-token"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("adminHandler returned %d for POST", rec.Code)
+	}
+
+	rec := adminRequest(rp, "GET", "/admin/pulls", "admin-token")
+	var list struct {
+		Repo  string     `json:"repo"`
+		Pulls []pullView `json:"pulls"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal pulls: %s", err)
+	}
+	if list.Repo != "testing/repo" || len(list.Pulls) != 2 || list.Pulls[0].Number != 1 || list.Pulls[1].Number != 2 {
+		t.Fatalf("adminHandler listed incorrect pulls: %+v", list)
+	}
+
+	rec = adminRequest(rp, "GET", "/admin/pulls/1", "admin-token")
+	var view pullView
+	err = json.Unmarshal(rec.Body.Bytes(), &view)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal pull: %s", err)
+	}
+	if view.Head != "hash" || view.Author != "roland" || view.Approvals["alice"] != 10 {
+		t.Fatalf("adminHandler returned incorrect pull: %+v", view)
+	}
+	if view.Evaluation.State != "pending" || view.Evaluation.Description != "1 of 2 required reviews" {
+		t.Fatalf("adminHandler returned incorrect evaluation: %+v", view.Evaluation)
+	}
+
+	for path, code := range map[string]int{
+		"/admin/pulls/3":   http.StatusNotFound,
+		"/admin/pulls/abc": http.StatusBadRequest,
+		"/admin/other":     http.StatusNotFound,
+	} {
+		if rec := adminRequest(rp, "GET", path, "admin-token"); rec.Code != code {
+			t.Fatalf("adminHandler returned %d for %s, expected %d", rec.Code, path, code)
+		}
+	}
+
+	rec = adminRequest(rp, "GET", "/admin/config", "admin-token")
+	if strings.Contains(rec.Body.String(), "webhook-secret") || strings.Contains(rec.Body.String(), "admin-token") {
+		t.Fatalf("adminHandler returned secrets in config: %s", rec.Body.String())
+	}
+	var config configView
+	err = json.Unmarshal(rec.Body.Bytes(), &config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal config: %s", err)
+	}
+	if config.Repo != "testing/repo" || config.RequiredReviews != 2 || len(config.Reviewers) != 2 || config.CollaboratorTTL != "10m0s" {
+		t.Fatalf("adminHandler returned incorrect config: %+v", config)
+	}
+}
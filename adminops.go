@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// statusStates are the states a status can be manually set to.
+var statusStates = map[string]struct{}{
+	"pending": struct{}{},
+	"success": struct{}{},
+	"failure": struct{}{},
+	"error":   struct{}{},
+}
+
+// resync brings the tracked state of a pull up to date with GitHub, for
+// instance after a missed webhook. If the head has changed it's handled
+// like a new commit pushed when the head commit was made, the labels are
+// refreshed, and approvals and withdrawals made in comments since the head
+// was pushed are replayed. Edited comments are skipped unless
+// rp.countEdited is set.
+func (rp *rplus) resync(pr int) error {
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	p, _, err := client.PullRequests.Get(owner, repo, pr)
+	if err != nil {
+		return err
+	}
+	if p.State != nil && *p.State == "closed" {
+		rp.closed(rp.log, pr)
+		return nil
+	}
+	if p.Head == nil || p.Head.SHA == nil || p.User == nil || p.User.Login == nil {
+		return fmt.Errorf("pull request #%d is missing its head or author", pr)
+	}
+	base := ""
+	if p.Base != nil && p.Base.Ref != nil {
+		base = *p.Base.Ref
+	}
+
+	rp.pMu.Lock()
+	o, present := rp.pending[pr]
+	current := present && o.currentHash == *p.Head.SHA
+	rp.pMu.Unlock()
+	if !current {
+		rp.newCommit(rp.log, pr, *p.Head.SHA, *p.User.Login, base, nil)
+		// the push was missed, so date it by the head commit rather than
+		// now so approvals made on it since are replayed
+		commit, _, err := client.Git.GetCommit(owner, repo, *p.Head.SHA)
+		if err != nil {
+			return err
+		}
+		if commit.Committer != nil && commit.Committer.Date != nil {
+			rp.pMu.Lock()
+			if o, present := rp.pending[pr]; present && o.currentHash == *p.Head.SHA && commit.Committer.Date.Before(o.pushed) {
+				o.pushed = *commit.Committer.Date
+				rp.saveState()
+			}
+			rp.pMu.Unlock()
+		}
+	}
+
+	labels := []string{}
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.Issues.ListLabelsByIssue(owner, repo, pr, opt)
+		if err != nil {
+			return err
+		}
+		for _, l := range page {
+			if l.Name != nil {
+				labels = append(labels, *l.Name)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	rp.newLabels(rp.log, pr, labels)
+
+	rp.pMu.Lock()
+	o, present = rp.pending[pr]
+	if !present {
+		rp.pMu.Unlock()
+		return nil
+	}
+	head, pushed := o.currentHash, o.pushed
+	rp.pMu.Unlock()
+	commentOpt := &github.IssueListCommentsOptions{
+		Sort:        "created",
+		Direction:   "asc",
+		Since:       pushed,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := client.Issues.ListComments(owner, repo, pr, commentOpt)
+		if err != nil {
+			return err
+		}
+		for _, c := range comments {
+			if c.CreatedAt == nil || c.CreatedAt.Before(pushed) || c.User == nil || c.User.Login == nil || c.Body == nil || c.ID == nil {
+				continue
+			}
+			if c.UpdatedAt != nil && !c.UpdatedAt.Equal(*c.CreatedAt) && !rp.countEdited {
+				continue
+			}
+			rp.replay(pr, head, *c.User.Login, *c.ID, *c.Body)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		commentOpt.Page = resp.NextPage
+	}
+	return nil
+}
+
+// replay applies the approvals and withdrawals in a comment, ignoring any
+// other commands since they have side effects that were already applied
+// or shouldn't be repeated.
+func (rp *rplus) replay(pr int, head, user string, commentID int, body string) {
+	cmds := parseCommands(body, rp.botName)
+	if len(cmds) == 0 {
+		cmds = rp.patternCommands(body)
+	}
+	for _, cmd := range cmds {
+		switch cmd.name {
+		case "r+":
+			if strings.HasPrefix(cmd.text, "override:") {
+				continue
+			}
+			sha := ""
+			for _, arg := range cmd.args {
+				if shaPattern.MatchString(arg) {
+					sha = arg
+				}
+			}
+			if sha != "" && !strings.HasPrefix(head, sha) {
+				continue
+			}
+			rp.newPlus(rp.log, pr, user, sha, commentID)
+		case "r-":
+			rp.newMinus(rp.log, pr, user)
+		}
+	}
+}
+
+// clearApprovals removes every approval of the current head of a pull.
+func (rp *rplus) clearApprovals(pr int) error {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		return fmt.Errorf("unknown pull request #%d", pr)
+	}
+	o.approvals = make(map[string]int)
+	err := rp.reevaluate(rp.log, pr, o)
+	rp.saveState()
+	return err
+}
+
+// setStatus manually posts a status for the current head of a pull. The
+// change is recorded in the audit log and is replaced the next time the
+// pull is re-evaluated.
+func (rp *rplus) setStatus(pr int, user, state, desc, reason string) error {
+	if _, present := statusStates[state]; !present {
+		return fmt.Errorf("invalid state '%s'", state)
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return fmt.Errorf("a reason is required")
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		return fmt.Errorf("unknown pull request #%d", pr)
+	}
+	err := rp.audit(auditEntry{
+		Time:   rp.now().UTC(),
+		Repo:   rp.repo,
+		PR:     pr,
+		Commit: o.currentHash,
+		User:   user,
+		Reason: reason,
+		Action: "set-status",
+		State:  state,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %s", err)
+	}
+	err = rp.updateStatus(rp.log, pr, o.currentHash, state, desc)
+	if err != nil {
+		return err
+	}
+	o.state, o.desc = state, desc
+	rp.saveState()
+	return nil
+}
+
+// repostAll re-evaluates every tracked pull and posts its status even if
+// it hasn't changed. It returns the pulls whose status couldn't be posted.
+func (rp *rplus) repostAll() []int {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	failed := []int{}
+	for _, pr := range rp.sortedPulls() {
+		o := rp.pending[pr]
+		o.state, o.desc = "", ""
+		err := rp.reevaluate(rp.log, pr, o)
+		if err != nil {
+			rp.log.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+			failed = append(failed, pr)
+		}
+	}
+	rp.saveState()
+	return failed
+}
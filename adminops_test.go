@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestResync(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	now := time.Date(2016, time.April, 1, 0, 0, 0, 0, time.UTC)
+	head := "new-hash"
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(github.PullRequest{
+			State: github.String("open"),
+			Head:  &github.PullRequestBranch{SHA: github.String(head)},
+			Base:  &github.PullRequestBranch{Ref: github.String("master")},
+			User:  &github.User{Login: github.String("roland")},
+		})
+	})
+	mux.HandleFunc("/repos/testing/repo/issues/1/labels", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]github.Label{{Name: github.String("bug")}})
+	})
+	mux.HandleFunc("/repos/testing/repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("resync posted a comment")
+		}
+		comment := func(id int, user, body string, created time.Time) github.IssueComment {
+			return github.IssueComment{ID: github.Int(id), User: &github.User{Login: github.String(user)}, Body: github.String(body), CreatedAt: &created}
+		}
+		edited := comment(7, "erin", "@rplus r+", now.Add(-4*time.Minute))
+		updated := now.Add(-2 * time.Minute)
+		edited.UpdatedAt = &updated
+		json.NewEncoder(w).Encode([]github.IssueComment{
+			comment(1, "alice", "@rplus r+", now.Add(-time.Hour)),
+			comment(2, "bob", "@rplus r+", now.Add(-5*time.Minute)),
+			comment(3, "carol", "@rplus r+ abcdef0", now.Add(-5*time.Minute)),
+			comment(4, "carol", "@rplus r+ override: outage", now.Add(-5*time.Minute)),
+			comment(5, "dave", "@rplus r+", now.Add(-5*time.Minute)),
+			comment(6, "dave", "@rplus r-", now.Add(-4*time.Minute)),
+			edited,
+		})
+	})
+	mux.HandleFunc("/repos/testing/repo/git/commits/new-hash", func(w http.ResponseWriter, r *http.Request) {
+		committed := now.Add(-10 * time.Minute)
+		json.NewEncoder(w).Encode(github.Commit{Committer: &github.CommitAuthor{Date: &committed}})
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}, "carol": struct{}{}, "dave": struct{}{}, "erin": struct{}{}},
+		requiredReviews: 2,
+		botName:         "rplus",
+		overriders:      map[string]struct{}{"carol": struct{}{}},
+		clock:           func() time.Time { return now },
+	}
+	rp.newCommit(nil, 1, "old-hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "alice", "", 1)
+
+	// a missed push is picked up, and only the unedited approvals made
+	// since the head was committed are replayed
+	err := rp.resync(1)
+	if err != nil {
+		t.Fatalf("resync failed: %s", err)
+	}
+	o := rp.pending[1]
+	if o.currentHash != "new-hash" {
+		t.Fatalf("resync didn't update head: %s", o.currentHash)
+	}
+	if _, present := o.labels["bug"]; !present {
+		t.Fatal("resync didn't update labels")
+	}
+	if len(o.approvals) != 1 || o.approvals["bob"] != 2 {
+		t.Fatalf("resync replayed incorrect approvals: %v", o.approvals)
+	}
+	if o.overrideBy != "" {
+		t.Fatal("resync replayed an override")
+	}
+	if !o.pushed.Equal(now.Add(-10 * time.Minute)) {
+		t.Fatalf("resync dated push incorrectly: %s", o.pushed)
+	}
+
+	// resyncing an up to date pull keeps its approvals
+	o.approvals["frank"] = 7
+	err = rp.resync(1)
+	if err != nil {
+		t.Fatalf("resync failed: %s", err)
+	}
+	if rp.pending[1] != o || o.approvals["frank"] != 7 {
+		t.Fatal("resync of unchanged head reset the pull")
+	}
+}
+
+func TestAdminWrites(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	auditLog := filepath.Join(dir, "audit.log")
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		admin:           adminConfig{Token: "admin-token"},
+		auditLog:        auditLog,
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newCommit(nil, 2, "other-hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "alice", "", 0)
+	statusPath := "/repos/testing/repo/statuses/hash"
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rec := httptest.NewRecorder()
+		rp.adminHandler(rec, req)
+		return rec
+	}
+
+	if rec := post("/admin/pulls/1/clear", ""); rec.Code != http.StatusOK {
+		t.Fatalf("clear returned %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(rp.pending[1].approvals) != 0 || ta.hits[statusPath] != "pending" {
+		t.Fatal("clear didn't remove approvals")
+	}
+
+	for body, code := range map[string]int{
+		`{"state": "success", "reason": "ci outage"}`:               http.StatusBadRequest,
+		`{"user": "op", "state": "success"}`:                        http.StatusBadRequest,
+		`{"user": "op", "state": "great", "reason": "ci outage"}`:   http.StatusBadRequest,
+		`{"user": "op", "state": "success", "reason": "ci outage"}`: http.StatusOK,
+	} {
+		if rec := post("/admin/pulls/1/status", body); rec.Code != code {
+			t.Fatalf("status returned %d for %s: %s", rec.Code, body, rec.Body.String())
+		}
+	}
+	if ta.hits[statusPath] != "success" {
+		t.Fatal("status wasn't posted")
+	}
+	f, err := os.Open(auditLog)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	entries := []auditEntry{}
+	for scanner.Scan() {
+		var entry auditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal audit entry: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 1 || entries[0].User != "op" || entries[0].Action != "set-status" || entries[0].State != "success" || entries[0].Reason != "ci outage" {
+		t.Fatalf("audit log has incorrect entries: %+v", entries)
+	}
+
+	// re-posting re-evaluates every pull
+	delete(ta.hits, statusPath)
+	delete(ta.hits, "/repos/testing/repo/statuses/other-hash")
+	if rec := post("/admin/repost", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"failed":[]`) {
+		t.Fatalf("repost returned %d: %s", rec.Code, rec.Body.String())
+	}
+	if ta.hits[statusPath] != "pending" || ta.hits["/repos/testing/repo/statuses/other-hash"] != "pending" {
+		t.Fatalf("repost didn't post statuses: %v", ta.hits)
+	}
+
+	if rec := post("/admin/pulls/3/clear", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("clear of unknown pull returned %d", rec.Code)
+	}
+	req := httptest.NewRequest("GET", "/admin/repost", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	rp.adminHandler(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET repost returned %d", rec.Code)
+	}
+}
+
+func TestAdminCLI(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	api := httptest.NewServer(ta)
+	defer api.Close()
+	apiBase = api.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		admin:           adminConfig{Token: "admin-token", Prefix: "/ops"},
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "alice", "", 0)
+	admin := httptest.NewServer(http.HandlerFunc(rp.adminHandler))
+	defer admin.Close()
+
+	var c config
+	c.Admin = adminConfig{Addr: strings.TrimPrefix(admin.URL, "http://"), Prefix: "/ops", Token: "admin-token"}
+	out := new(bytes.Buffer)
+	err := adminCLI(c, []string{"pull", "1"}, out)
+	if err != nil {
+		t.Fatalf("admin pull failed: %s", err)
+	}
+	if !strings.Contains(out.String(), `"head": "hash"`) {
+		t.Fatalf("admin pull wrote incorrect output: %s", out.String())
+	}
+	err = adminCLI(c, []string{"clear", "1"}, out)
+	if err != nil {
+		t.Fatalf("admin clear failed: %s", err)
+	}
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatal("admin clear didn't clear approvals")
+	}
+	err = adminCLI(c, []string{"pull", "2"}, out)
+	if err == nil || err.Error() != "unknown pull request" {
+		t.Fatalf("admin pull of unknown pull returned %v", err)
+	}
+	err = adminCLI(c, []string{"bogus"}, out)
+	if err == nil || !strings.HasPrefix(err.Error(), "usage:") {
+		t.Fatalf("admin with unknown command returned %v", err)
+	}
+
+	// with TLS the certificate doesn't match the loopback address r-plus
+	// connects to by default
+	tlsAdmin := httptest.NewTLSServer(http.HandlerFunc(rp.adminHandler))
+	defer tlsAdmin.Close()
+	c.Admin.Addr = strings.TrimPrefix(tlsAdmin.URL, "https://")
+	c.WebhookServer.Cert, c.WebhookServer.CertKey = "cert.pem", "key.pem"
+	if err := adminCLI(c, []string{"pulls"}, out); err == nil {
+		t.Fatal("admin verified an untrusted certificate")
+	}
+	err = adminCLI(c, []string{"-url", tlsAdmin.URL + "/ops/", "-insecure-skip-verify", "pull", "1"}, out)
+	if err != nil {
+		t.Fatalf("admin pull with -url and -insecure-skip-verify failed: %s", err)
+	}
+	c.WebhookServer.Cert, c.WebhookServer.CertKey = "", ""
+
+	c.WebhookServer.Addr = "0.0.0.0:3344"
+	c.Admin.Addr = ""
+	if u, _ := adminURL(c); u != "http://127.0.0.1:3344/ops" {
+		t.Fatalf("adminURL returned %s", u)
+	}
+	c.Admin.Addr = "127.0.0.1:3345"
+	c.WebhookServer.Cert, c.WebhookServer.CertKey = "cert.pem", "key.pem"
+	if u, _ := adminURL(c); u != "https://127.0.0.1:3345/ops" {
+		t.Fatalf("adminURL with TLS returned %s", u)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// assignConfig describes how reviewers are picked for newly opened pulls.
+type assignConfig struct {
+	// Strategy is one of "round-robin", "least-loaded", or "codeowners",
+	// if empty reviewers aren't assigned automatically.
+	Strategy string `yaml:"strategy" json:"strategy"`
+	// Count is the number of reviewers to assign, defaulting to one.
+	Count int `yaml:"count" json:"count"`
+	// Pool is the set of users to pick from, defaulting to the reviewers.
+	Pool []string `yaml:"pool" json:"pool"`
+	// Away lists users who shouldn't be assigned, e.g. while on vacation.
+	Away []string `yaml:"away" json:"away"`
+	// CodeOwners is the path of the CODEOWNERS file in the repository.
+	CodeOwners string `yaml:"codeowners" json:"codeowners"`
+	// ReviewRequests requests reviews from the picked users instead of
+	// adding them as assignees.
+	ReviewRequests bool `yaml:"review-requests" json:"review-requests"`
+}
+
+var assignStrategies = map[string]struct{}{
+	"round-robin":  struct{}{},
+	"least-loaded": struct{}{},
+	"codeowners":   struct{}{},
+}
+
+// assignable returns true if the user can be assigned to a pull opened
+// by author.
+func (rp *rplus) assignable(user, author string) bool {
+	if user == author {
+		return false
+	}
+	for _, a := range rp.assign.Away {
+		if a == user {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the sorted pool of users that can be assigned to a
+// pull opened by author.
+func (rp *rplus) candidates(author string) []string {
+	pool := rp.assign.Pool
+	if len(pool) == 0 {
+		pool = make([]string, 0, len(rp.reviewers))
+		for r := range rp.reviewers {
+			pool = append(pool, r)
+		}
+	}
+	candidates := []string{}
+	for _, u := range pool {
+		if rp.assignable(u, author) {
+			candidates = append(candidates, u)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// load returns the number of tracked pulls which haven't been approved
+// yet that each user is assigned to. rp.pMu must be held by the caller.
+func (rp *rplus) load() map[string]int {
+	load := make(map[string]int)
+	for _, o := range rp.pending {
+		if o.state == "success" {
+			continue
+		}
+		for _, u := range o.assigned {
+			load[u]++
+		}
+	}
+	return load
+}
+
+// pickReviewers chooses reviewers for a pull using the configured
+// strategy. rp.pMu must be held by the caller.
+func (rp *rplus) pickReviewers(pr int, author, base string) ([]string, error) {
+	count := rp.assign.Count
+	if count < 1 {
+		count = 1
+	}
+	candidates := rp.candidates(author)
+	switch rp.assign.Strategy {
+	case "round-robin":
+		picked := []string{}
+		for i := 0; i < len(candidates) && len(picked) < count; i++ {
+			picked = append(picked, candidates[(rp.nextReviewer+i)%len(candidates)])
+		}
+		rp.nextReviewer += len(picked)
+		return picked, nil
+	case "least-loaded":
+		load := rp.load()
+		sort.Stable(byLoad{candidates, load})
+		if len(candidates) > count {
+			candidates = candidates[:count]
+		}
+		return candidates, nil
+	case "codeowners":
+		owners, err := rp.codeOwners(pr, base)
+		if err != nil {
+			return nil, err
+		}
+		// owners are only restricted to the pool if one is explicitly
+		// configured
+		pool := make(map[string]struct{}, len(rp.assign.Pool))
+		for _, u := range rp.assign.Pool {
+			pool[u] = struct{}{}
+		}
+		picked := []string{}
+		for _, o := range owners {
+			if _, present := pool[o]; len(pool) > 0 && !present {
+				continue
+			}
+			if rp.assignable(o, author) && len(picked) < count {
+				picked = append(picked, o)
+			}
+		}
+		return picked, nil
+	}
+	return nil, fmt.Errorf("unknown assignment strategy '%s'", rp.assign.Strategy)
+}
+
+type byLoad struct {
+	users []string
+	load  map[string]int
+}
+
+func (b byLoad) Len() int           { return len(b.users) }
+func (b byLoad) Swap(i, j int)      { b.users[i], b.users[j] = b.users[j], b.users[i] }
+func (b byLoad) Less(i, j int) bool { return b.load[b.users[i]] < b.load[b.users[j]] }
+
+// codeOwners returns the owners of the files changed by a pull according
+// to the CODEOWNERS file on the base branch, in the order they're listed.
+func (rp *rplus) codeOwners(pr int, base string) ([]string, error) {
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	file, _, _, err := client.Repositories.GetContents(owner, repo, rp.assign.CodeOwners, &github.RepositoryContentGetOptions{Ref: base})
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("'%s' is not a file", rp.assign.CodeOwners)
+	}
+	contents, err := file.Decode()
+	if err != nil {
+		return nil, err
+	}
+	rules := parseCodeOwners(contents)
+
+	owners := []string{}
+	seen := make(map[string]struct{})
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(owner, repo, pr, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.Filename == nil {
+				continue
+			}
+			for _, o := range ownersOf(rules, *f.Filename) {
+				if _, present := seen[o]; !present {
+					seen[o] = struct{}{}
+					owners = append(owners, o)
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return owners, nil
+}
+
+type codeOwnersRule struct {
+	pattern string
+	owners  []string
+}
+
+// parseCodeOwners parses a CODEOWNERS file, ignoring team and email
+// owners since only users can be assigned.
+func parseCodeOwners(contents []byte) []codeOwnersRule {
+	rules := []codeOwnersRule{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := codeOwnersRule{pattern: fields[0]}
+		for _, o := range fields[1:] {
+			if strings.HasPrefix(o, "@") && !strings.Contains(o, "/") {
+				rule.owners = append(rule.owners, o[1:])
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ownersOf returns the owners of a file, the last matching rule wins.
+func ownersOf(rules []codeOwnersRule, file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if codeOwnersMatch(rules[i].pattern, file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// codeOwnersMatch implements a subset of the gitignore style patterns used
+// in CODEOWNERS files.
+func codeOwnersMatch(pattern, file string) bool {
+	if pattern == "*" {
+		return true
+	}
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/")
+	parts := strings.Split(file, "/")
+	// try the pattern against every run of path elements, so patterns match
+	// files within matching directories and unanchored patterns match at
+	// any depth
+	for start := 0; start < len(parts); start++ {
+		if anchored && start > 0 {
+			break
+		}
+		for end := start + 1; end <= len(parts); end++ {
+			if matched, _ := path.Match(pattern, strings.Join(parts[start:end], "/")); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestReviewers asks users to review a pull, either by adding them as
+// assignees or by requesting their reviews.
+func (rp *rplus) requestReviewers(pr int, users []string) error {
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	u := fmt.Sprintf("repos/%s/%s/issues/%d/assignees", owner, repo, pr)
+	body := interface{}(struct {
+		Assignees []string `json:"assignees"`
+	}{users})
+	if rp.assign.ReviewRequests {
+		u = fmt.Sprintf("repos/%s/%s/pulls/%d/requested_reviewers", owner, repo, pr)
+		body = struct {
+			Reviewers []string `json:"reviewers"`
+		}{users}
+	}
+	req, err := client.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(req, nil)
+	return err
+}
+
+// autoAssign picks reviewers for a newly opened pull and requests their
+// reviews.
+func (rp *rplus) autoAssign(l *logger, pr int, author, base string) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		return
+	}
+	picked, err := rp.pickReviewers(pr, author, base)
+	if err != nil {
+		l.error("failed to pick reviewers", "pr", pr, "err", err)
+		return
+	}
+	if len(picked) == 0 {
+		l.warn("no reviewers available to assign", "pr", pr)
+		return
+	}
+	err = rp.requestReviewers(pr, picked)
+	if err != nil {
+		l.error("failed to request reviews", "pr", pr, "err", err)
+		return
+	}
+	o.assigned = append(o.assigned, picked...)
+	rp.saveState()
+}
+
+// assigned records that users were asked to review a pull.
+func (rp *rplus) assigned(pr int, users []string) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	if o, present := rp.pending[pr]; present {
+		o.assigned = append(o.assigned, users...)
+		rp.saveState()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestCodeOwnersMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		file    string
+		matched bool
+	}{
+		{"*", "main.go", true},
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/tool/main.go", true},
+		{"*.go", "README.md", false},
+		{"docs/", "docs/index.md", true},
+		{"docs/", "src/docs/index.md", true},
+		{"/docs/", "src/docs/index.md", false},
+		{"/docs/", "docs/a/b.md", true},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "lib/src/main.go", false},
+		{"vendor", "vendor/github.com/lib/lib.go", true},
+		{"main.go", "main.go.orig", false},
+	}
+	for _, tc := range testCases {
+		if matched := codeOwnersMatch(tc.pattern, tc.file); matched != tc.matched {
+			t.Errorf("codeOwnersMatch(%q, %q) returned %t, expected %t", tc.pattern, tc.file, matched, tc.matched)
+		}
+	}
+}
+
+func TestParseCodeOwners(t *testing.T) {
+	rules := parseCodeOwners([]byte("# owners\n*       @alice\n\n*.go    @bob @org/team carol@example.com\n/docs/ @carol\n"))
+	if len(rules) != 3 {
+		t.Fatalf("parseCodeOwners returned incorrect number of rules: %d", len(rules))
+	}
+	if owners := ownersOf(rules, "main.go"); !reflect.DeepEqual(owners, []string{"bob"}) {
+		t.Fatalf("ownersOf returned incorrect owners for main.go: %v", owners)
+	}
+	if owners := ownersOf(rules, "docs/main.go"); !reflect.DeepEqual(owners, []string{"carol"}) {
+		t.Fatalf("ownersOf returned incorrect owners for docs/main.go: %v", owners)
+	}
+	if owners := ownersOf(rules, "README.md"); !reflect.DeepEqual(owners, []string{"alice"}) {
+		t.Fatalf("ownersOf returned incorrect owners for README.md: %v", owners)
+	}
+}
+
+func TestPickReviewers(t *testing.T) {
+	rp := &rplus{
+		pending:   make(map[int]*pull),
+		reviewers: map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}, "carol": struct{}{}, "dave": struct{}{}},
+		assign:    assignConfig{Strategy: "round-robin", Away: []string{"dave"}},
+	}
+	picked := []string{}
+	for i := 0; i < 4; i++ {
+		p, err := rp.pickReviewers(i, "carol", "master")
+		if err != nil {
+			t.Fatalf("Failed to pick reviewers: %s", err)
+		}
+		picked = append(picked, p...)
+	}
+	if !reflect.DeepEqual(picked, []string{"alice", "bob", "alice", "bob"}) {
+		t.Fatalf("round-robin picked incorrect reviewers: %v", picked)
+	}
+
+	rp.assign = assignConfig{Strategy: "least-loaded", Count: 2}
+	rp.pending[1] = &pull{assigned: []string{"alice", "bob"}}
+	rp.pending[2] = &pull{assigned: []string{"alice"}}
+	rp.pending[3] = &pull{assigned: []string{"carol", "dave"}, state: "success"}
+	p, err := rp.pickReviewers(4, "dave", "master")
+	if err != nil {
+		t.Fatalf("Failed to pick reviewers: %s", err)
+	}
+	if !reflect.DeepEqual(p, []string{"carol", "bob"}) {
+		t.Fatalf("least-loaded picked incorrect reviewers: %v", p)
+	}
+
+	rp.assign.Strategy = "unknown"
+	_, err = rp.pickReviewers(4, "dave", "master")
+	if err == nil {
+		t.Fatal("pickReviewers accepted an unknown strategy")
+	}
+}
+
+func TestAutoAssign(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	requested := []string{}
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/contents/.github/CODEOWNERS", func(w http.ResponseWriter, r *http.Request) {
+		if ref := r.URL.Query().Get("ref"); ref != "master" {
+			t.Fatalf("CODEOWNERS fetched from incorrect ref: %s", ref)
+		}
+		content := base64.StdEncoding.EncodeToString([]byte("*.go @alice @roland\n/docs/ @bob\n"))
+		fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": "%s"}`, content)
+	})
+	mux.HandleFunc("/repos/testing/repo/pulls/1/files", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"filename": "main.go"}, {"filename": "docs/index.md"}]`)
+	})
+	mux.HandleFunc("/repos/testing/repo/pulls/1/requested_reviewers", func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Reviewers []string }
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal review request: %s", err)
+		}
+		requested = request.Reviewers
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		assign: assignConfig{
+			Strategy:       "codeowners",
+			Count:          3,
+			CodeOwners:     ".github/CODEOWNERS",
+			ReviewRequests: true,
+		},
+	}
+
+	roland, action, num, sha, base := "roland", "opened", 1, "hash", "master"
+	body, err := json.Marshal(github.PullRequestEvent{
+		Action: &action,
+		Number: &num,
+		PullRequest: &github.PullRequest{
+			Head: &github.PullRequestBranch{SHA: &sha},
+			Base: &github.PullRequestBranch{Ref: &base},
+			User: &github.User{Login: &roland},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal PullRequestEvent: %s", err)
+	}
+	rp.prHandler(nil, body, httptest.NewRecorder())
+	if !reflect.DeepEqual(requested, []string{"alice", "bob"}) {
+		t.Fatalf("incorrect reviewers requested: %v", requested)
+	}
+	if !reflect.DeepEqual(rp.pending[1].assigned, []string{"alice", "bob"}) {
+		t.Fatalf("assigned reviewers weren't recorded: %v", rp.pending[1].assigned)
+	}
+}
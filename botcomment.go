@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/google/go-github/github"
+)
+
+// defaultTemplates are used for any message which isn't overridden in
+// the configuration. "summary" renders the sticky bot comment, the rest
+// explain why an approval was ignored.
+var defaultTemplates = map[string]string{
+	"summary": `r-plus status for {{.Head}}: **{{.State}}** ({{.Description}})
+{{range .Approvals}}
+* approved by @{{.}}{{else}}
+No approvals yet.{{end}}
+{{with .Note}}
+{{.}}{{end}}`,
+	"not-reviewer": "@{{.Reviewer}} isn't a reviewer, their approval wasn't counted.",
+	"self-review":  "@{{.Reviewer}} can't approve their own pull request.",
+	"unknown-pull": "@{{.Reviewer}} r-plus isn't tracking this pull request, push a new commit so it can be reviewed.",
+	"stale-commit": "@{{.Reviewer}} your approval was for {{.SHA}} but the head of this pull request is now {{.Head}}, it has not been counted.",
+}
+
+// parseTemplates parses the default templates with any overrides.
+func parseTemplates(overrides map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(defaultTemplates))
+	for name := range overrides {
+		if _, present := defaultTemplates[name]; !present {
+			return nil, fmt.Errorf("unknown template '%s'", name)
+		}
+	}
+	for name, text := range defaultTemplates {
+		if override, present := overrides[name]; present {
+			text = override
+		}
+		t, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+type summaryData struct {
+	Head        string
+	State       string
+	Description string
+	Approvals   []string
+	Note        string
+}
+
+type messageData struct {
+	Reviewer string
+	SHA      string
+	Head     string
+}
+
+func (rp *rplus) render(name string, data interface{}) (string, error) {
+	if rp.templates == nil {
+		templates, err := parseTemplates(nil)
+		if err != nil {
+			return "", err
+		}
+		rp.templates = templates
+	}
+	buf := new(bytes.Buffer)
+	err := rp.templates[name].Execute(buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ignored explains why an approval wasn't counted. If bot comments are
+// enabled the explanation is added to the sticky comment, otherwise only
+// approvals of stale commits get a reply. rp.pMu must be held by the
+// caller.
+func (rp *rplus) ignored(l *logger, pr int, o *pull, reason, reviewer, sha string) {
+	if !rp.botComments && reason != "stale-commit" {
+		return
+	}
+	data := messageData{Reviewer: reviewer, SHA: sha}
+	if o != nil {
+		data.Head = shortHash(o.currentHash)
+	}
+	msg, err := rp.render(reason, data)
+	if err != nil {
+		l.error("failed to render message", "pr", pr, "template", reason, "err", err)
+		return
+	}
+	if !rp.botComments || o == nil {
+		err = rp.comment(pr, msg)
+		if err != nil {
+			l.error("failed to comment", "pr", pr, "err", err)
+		}
+		return
+	}
+	o.note = msg
+	rp.updateBotComment(l, pr, o)
+}
+
+// summary renders the summary of the state of a pull.
+func (rp *rplus) summary(o *pull) (string, error) {
+	approvals := make([]string, 0, len(o.approvals))
+	for reviewer := range o.approvals {
+		approvals = append(approvals, reviewer)
+	}
+	sort.Strings(approvals)
+	return rp.render("summary", summaryData{
+		Head:        shortHash(o.currentHash),
+		State:       o.state,
+		Description: o.desc,
+		Approvals:   approvals,
+		Note:        o.note,
+	})
+}
+
+// updateBotComment creates or edits the sticky comment summarizing the
+// state of a pull. rp.pMu must be held by the caller.
+func (rp *rplus) updateBotComment(l *logger, pr int, o *pull) {
+	body, err := rp.summary(o)
+	if err != nil {
+		l.error("failed to render summary", "pr", pr, "err", err)
+		return
+	}
+
+	owner, repo := rp.ownerRepo()
+	comment := &github.IssueComment{Body: &body}
+	if o.botComment != 0 {
+		_, _, err = rp.gh().Issues.EditComment(owner, repo, o.botComment, comment)
+	} else {
+		comment, _, err = rp.gh().Issues.CreateComment(owner, repo, pr, comment)
+		if err == nil && comment.ID != nil {
+			o.botComment = *comment.ID
+		}
+	}
+	if err != nil {
+		l.error("failed to update bot comment", "pr", pr, "err", err)
+	}
+}
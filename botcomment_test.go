@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestBotComment(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	created, sticky := []string{}, ""
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	readComment := func(r *http.Request) string {
+		var comment github.IssueComment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal comment: %s", err)
+		}
+		return *comment.Body
+	}
+	mux.HandleFunc("/repos/testing/repo/issues/", func(w http.ResponseWriter, r *http.Request) {
+		created = append(created, readComment(r))
+		fmt.Fprintf(w, `{"id": %d}`, 41+len(created))
+	})
+	mux.HandleFunc("/repos/testing/repo/issues/comments/42", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			t.Fatalf("Sticky comment updated with wrong method: %s", r.Method)
+		}
+		sticky = readComment(r)
+		fmt.Fprint(w, `{"id": 42}`)
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	templates, err := parseTemplates(map[string]string{"self-review": "nice try @{{.Reviewer}}"})
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %s", err)
+	}
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "roland": struct{}{}},
+		requiredReviews: 1,
+		botComments:     true,
+		templates:       templates,
+	}
+
+	rp.newCommit(nil, 1, "abcdef0123456789", "roland", "master", nil)
+	if len(created) != 1 || !strings.Contains(created[0], "No approvals yet") {
+		t.Fatalf("sticky comment wasn't created: %v", created)
+	}
+	if rp.pending[1].botComment != 42 {
+		t.Fatalf("sticky comment ID wasn't recorded: %d", rp.pending[1].botComment)
+	}
+
+	rp.newPlus(nil, 1, "mallory", "", 0)
+	if !strings.Contains(sticky, "@mallory isn't a reviewer") {
+		t.Fatalf("sticky comment doesn't explain ignored approval: %s", sticky)
+	}
+	rp.newPlus(nil, 1, "roland", "", 0)
+	if !strings.Contains(sticky, "nice try @roland") {
+		t.Fatalf("sticky comment doesn't use configured template: %s", sticky)
+	}
+	rp.newPlus(nil, 1, "alice", "1234567", 0)
+	if !strings.Contains(sticky, "approval was for 1234567 but the head of this pull request is now abcdef0") {
+		t.Fatalf("sticky comment doesn't explain stale approval: %s", sticky)
+	}
+	rp.newPlus(nil, 1, "alice", "", 0)
+	if !strings.Contains(sticky, "**success**") || !strings.Contains(sticky, "approved by @alice") {
+		t.Fatalf("sticky comment doesn't summarize approvals: %s", sticky)
+	}
+	if strings.Contains(sticky, "stale") {
+		t.Fatalf("sticky comment still explains ignored approval after approval: %s", sticky)
+	}
+
+	// new commits keep editing the same comment
+	rp.newCommit(nil, 1, "other-hash", "roland", "master", nil)
+	if len(created) != 1 || !strings.Contains(sticky, "other-h") {
+		t.Fatalf("new commit didn't update sticky comment: %d created, %s", len(created), sticky)
+	}
+
+	rp.newPlus(nil, 2, "alice", "", 0)
+	if len(created) != 2 || !strings.Contains(created[1], "isn't tracking") {
+		t.Fatalf("no reply sent for approval of unknown pull: %v", created)
+	}
+
+	_, err = parseTemplates(map[string]string{"unknown": "hi"})
+	if err == nil {
+		t.Fatal("parseTemplates accepted an unknown template")
+	}
+}
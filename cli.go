@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const adminUsage = `usage: r-plus [-config path] admin [-user name] [-description text] [-url base] [-insecure-skip-verify] <command>
+
+commands:
+  pulls                           list the tracked pull requests
+  pull <number>                   show a single pull request
+  config                          show the effective configuration
+  resync <number>                 re-sync a pull request from GitHub
+  clear <number>                  clear the approvals of a pull request
+  set-status <number> <state> <reason...>
+                                  set the status of a pull request
+  repost                          re-post the status of every pull request
+`
+
+// adminURL returns the base URL of the admin API described by the config.
+// Both the webhook server and a separate admin listener use TLS if the
+// webhook certificate is set.
+func adminURL(c config) (string, error) {
+	addr, scheme := c.Admin.Addr, "http"
+	if addr == "" {
+		addr = c.WebhookServer.Addr
+	}
+	if c.WebhookServer.Cert != "" && c.WebhookServer.CertKey != "" {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	prefix := strings.TrimSuffix(c.Admin.Prefix, "/")
+	if prefix == "" {
+		prefix = "/admin"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, port), prefix), nil
+}
+
+// adminCLI runs a command against the admin API and writes the response
+// to out.
+func adminCLI(c config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	user := fs.String("user", os.Getenv("USER"), "Operator making the change")
+	description := fs.String("description", "", "Description of a status set with set-status")
+	baseURL := fs.String("url", "", "Base URL of the admin API, instead of one derived from the config")
+	insecure := fs.Bool("insecure-skip-verify", false, "Don't verify the admin API's certificate")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s\n%s", err, adminUsage)
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New(adminUsage)
+	}
+	if c.Admin.Token == "" {
+		return fmt.Errorf("the admin API is disabled, admin.token isn't set")
+	}
+	base := strings.TrimSuffix(*baseURL, "/")
+	if base == "" {
+		var err error
+		base, err = adminURL(c)
+		if err != nil {
+			return err
+		}
+	}
+	client := http.DefaultClient
+	if *insecure {
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	}
+
+	method, path := "GET", ""
+	action := adminAction{User: *user}
+	switch {
+	case len(args) == 1 && (args[0] == "pulls" || args[0] == "config"):
+		path = "/" + args[0]
+	case len(args) == 1 && args[0] == "repost":
+		method, path = "POST", "/repost"
+	case len(args) == 2 && args[0] == "pull":
+		path = "/pulls/" + args[1]
+	case len(args) == 2 && (args[0] == "resync" || args[0] == "clear"):
+		method, path = "POST", fmt.Sprintf("/pulls/%s/%s", args[1], args[0])
+	case len(args) >= 4 && args[0] == "set-status":
+		method, path = "POST", fmt.Sprintf("/pulls/%s/status", args[1])
+		action.State = args[2]
+		action.Description = *description
+		action.Reason = strings.Join(args[3:], " ")
+	default:
+		return errors.New(adminUsage)
+	}
+
+	var body io.Reader
+	if method == "POST" {
+		data, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Admin.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(content, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s", e.Error)
+		}
+		return fmt.Errorf("unexpected response status code %d", resp.StatusCode)
+	}
+	indented := new(bytes.Buffer)
+	if json.Indent(indented, content, "", "  ") == nil {
+		content = indented.Bytes()
+	}
+	_, err = out.Write(content)
+	return err
+}
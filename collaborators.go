@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type cachedPermission struct {
+	push    bool
+	expires time.Time
+}
+
+// hasPush returns true if the user has push access to the repository,
+// checking the collaborator permission API and caching the result for
+// rp.collaboratorTTL.
+func (rp *rplus) hasPush(user string) (bool, error) {
+	rp.cMu.Lock()
+	defer rp.cMu.Unlock()
+	if cached, present := rp.collaborators[user]; present && rp.now().Before(cached.expires) {
+		return cached.push, nil
+	}
+
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	req, err := client.NewRequest(
+		"GET",
+		fmt.Sprintf("repos/%s/%s/collaborators/%s/permission", owner, repo, url.QueryEscape(user)),
+		nil,
+	)
+	if err != nil {
+		return false, err
+	}
+	var level struct {
+		Permission string `json:"permission"`
+	}
+	resp, err := client.Do(req, &level)
+	if resp != nil && resp.StatusCode == 404 {
+		// not a collaborator at all
+		err = nil
+	} else if err != nil {
+		return false, err
+	}
+	push := level.Permission == "admin" || level.Permission == "write"
+
+	if rp.collaborators == nil {
+		rp.collaborators = make(map[string]cachedPermission)
+	}
+	rp.collaborators[user] = cachedPermission{push: push, expires: rp.now().Add(rp.collaboratorTTL)}
+	return push, nil
+}
+
+// isReviewer returns true if the user is allowed to approve pull requests.
+// If rp.checkCollaborators is set the user must also have push access to
+// the repository, and if no reviewers or groups are configured any user
+// with push access may approve.
+func (rp *rplus) isReviewer(l *logger, user string) bool {
+	if !rp.checkCollaborators {
+		return rp.canReview(user)
+	}
+	if (len(rp.reviewers) > 0 || len(rp.groups) > 0) && !rp.canReview(user) {
+		return false
+	}
+	push, err := rp.hasPush(user)
+	if err != nil {
+		l.error("failed to check permissions", "user", user, "err", err)
+		return false
+	}
+	return push
+}
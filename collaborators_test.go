@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollaboratorCheck(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	permissions := map[string]string{"alice": "write", "bob": "read"}
+	lookups := 0
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/collaborators/", func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		user := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/testing/repo/collaborators/"), "/permission")
+		permission, present := permissions[user]
+		if !present {
+			w.WriteHeader(404)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"permission": "%s"}`, permission)
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	now := time.Now()
+	rp := &rplus{
+		pending:            make(map[int]*pull),
+		client:             new(http.Client),
+		repo:               "testing/repo",
+		requiredReviews:    2,
+		checkCollaborators: true,
+		collaboratorTTL:    time.Minute,
+		clock:              func() time.Time { return now },
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+
+	rp.newPlus(nil, 1, "mallory", "", 0)
+	rp.newPlus(nil, 1, "bob", "", 0)
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("approval counted from user without push access: %v", rp.pending[1].approvals)
+	}
+	rp.newPlus(nil, 1, "alice", "", 0)
+	if _, present := rp.pending[1].approvals["alice"]; !present {
+		t.Fatal("approval from user with push access wasn't counted")
+	}
+
+	lookups = 0
+	permissions["bob"] = "admin"
+	rp.newPlus(nil, 1, "bob", "", 0)
+	if lookups != 0 || len(rp.pending[1].approvals) != 1 {
+		t.Fatalf("cached permission wasn't used: %d lookups", lookups)
+	}
+	now = now.Add(2 * time.Minute)
+	rp.newPlus(nil, 1, "bob", "", 0)
+	if lookups != 1 || ta.hits["/repos/testing/repo/statuses/hash"] != "success" {
+		t.Fatalf("expired permission wasn't refreshed: %d lookups", lookups)
+	}
+
+	// listed reviewers still need push access
+	rp.reviewers = map[string]struct{}{"carol": struct{}{}, "alice": struct{}{}}
+	if rp.isReviewer(nil, "carol") {
+		t.Fatal("listed reviewer without push access allowed to approve")
+	}
+	if rp.isReviewer(nil, "bob") {
+		t.Fatal("unlisted user with push access allowed to approve")
+	}
+	if !rp.isReviewer(nil, "alice") {
+		t.Fatal("listed reviewer with push access not allowed to approve")
+	}
+}
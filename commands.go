@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// command is a single instruction addressed to the bot in a comment, e.g.
+// "@rplus r? @bob" is the command "r?" with the arguments ["@bob"].
+type command struct {
+	name string
+	args []string
+	text string // arguments joined by single spaces
+}
+
+// commandContext describes the comment a command was found in.
+type commandContext struct {
+	pr        int
+	user      string
+	commentID int
+	// log includes the delivery ID of the webhook the command came from
+	log *logger
+}
+
+type commandHandler func(rp *rplus, ctx commandContext, cmd command)
+
+// commands maps command names to their handlers, new commands are added
+// using registerCommand.
+var commands = map[string]commandHandler{}
+
+func registerCommand(name string, handler commandHandler) {
+	commands[name] = handler
+}
+
+func init() {
+	registerCommand("r+", plusCommand)
+	registerCommand("r-", minusCommand)
+	registerCommand("r?", requestCommand)
+	registerCommand("status", statusCommand)
+	registerCommand("retry", retryCommand)
+}
+
+var (
+	fencePattern      = regexp.MustCompile("^\\s*(```|~~~)")
+	inlineCodePattern = regexp.MustCompile("`[^`]*`")
+	shaPattern        = regexp.MustCompile("^[0-9a-fA-F]{7,40}$")
+	overridePattern   = regexp.MustCompile(`(?m)^\s*r\+ override:(.*)$`)
+)
+
+// visibleLines returns the lines of a comment body which aren't part of a
+// quote or code block, with any inline code removed.
+func visibleLines(body string) []string {
+	lines := []string{}
+	fence := ""
+	for _, line := range strings.Split(strings.Replace(body, "\r\n", "\n", -1), "\n") {
+		if match := fencePattern.FindStringSubmatch(line); match != nil {
+			if fence == "" {
+				fence = match[1]
+			} else if fence == match[1] {
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" || strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		lines = append(lines, inlineCodePattern.ReplaceAllString(line, ""))
+	}
+	return lines
+}
+
+// isMention returns true if the word mentions the bot, ignoring case and
+// trailing punctuation like "@rplus:".
+func isMention(word, botName string) bool {
+	return strings.EqualFold(strings.TrimRight(word, ":,"), "@"+botName)
+}
+
+// parseCommands extracts the commands addressed to the bot from a comment
+// body. A command is the word following a mention of the bot, its arguments
+// are the remaining words up to the end of the line or the next mention.
+func parseCommands(body, botName string) []command {
+	cmds := []command{}
+	if botName == "" {
+		return cmds
+	}
+	for _, line := range visibleLines(body) {
+		words := strings.Fields(line)
+		for i := 0; i < len(words); i++ {
+			if !isMention(words[i], botName) {
+				continue
+			}
+			end := i + 1
+			for end < len(words) && !isMention(words[end], botName) {
+				end++
+			}
+			if end > i+1 {
+				args := words[i+2 : end]
+				cmds = append(cmds, command{
+					name: strings.ToLower(words[i+1]),
+					args: args,
+					text: strings.Join(args, " "),
+				})
+			}
+			i = end - 1
+		}
+	}
+	return cmds
+}
+
+// patternCommands extracts commands from a comment body using the
+// configured review pattern and the override pattern, for comments that
+// don't address the bot directly.
+func (rp *rplus) patternCommands(body string) []command {
+	text := strings.Join(visibleLines(body), "\n")
+	if match := overridePattern.FindStringSubmatch(text); match != nil {
+		return []command{{name: "r+", args: []string{"override:"}, text: "override:" + match[1]}}
+	}
+	if rp.reviewPattern == nil {
+		return nil
+	}
+	match := rp.reviewPattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	cmd := command{name: "r+"}
+	for i, name := range rp.reviewPattern.SubexpNames() {
+		if name == "sha" && match[i] != "" {
+			cmd.args = []string{match[i]}
+			cmd.text = match[i]
+		}
+	}
+	return []command{cmd}
+}
+
+// runCommands executes each command found in a comment body.
+func (rp *rplus) runCommands(ctx commandContext, body string) {
+	if ctx.log == nil {
+		ctx.log = rp.log
+	}
+	cmds := parseCommands(body, rp.botName)
+	if len(cmds) == 0 {
+		cmds = rp.patternCommands(body)
+	}
+	for _, cmd := range cmds {
+		handler, present := commands[cmd.name]
+		if !present {
+			ctx.log.info("ignoring unknown command", "pr", ctx.pr, "user", ctx.user, "command", cmd.name)
+			continue
+		}
+		handler(rp, ctx, cmd)
+	}
+}
+
+// plusCommand approves the pull, "r+ abc1234" only approves the given
+// commit, "r+ p=10" sets its merge queue priority, "r+ rollup" lets it be
+// merged in a batch, and "r+ override: reason" bypasses the review policy.
+func plusCommand(rp *rplus, ctx commandContext, cmd command) {
+	if strings.HasPrefix(cmd.text, "override:") {
+		rp.newOverride(ctx.log, ctx.pr, ctx.user, strings.TrimPrefix(cmd.text, "override:"))
+		return
+	}
+	sha := ""
+	for _, arg := range cmd.args {
+		if shaPattern.MatchString(arg) {
+			sha = arg
+		}
+	}
+	rp.newPlus(ctx.log, ctx.pr, ctx.user, sha, ctx.commentID)
+	if p, present := priorityArg(cmd.args); present {
+		rp.prioritize(ctx.log, ctx.pr, ctx.user, p)
+	}
+	for _, arg := range cmd.args {
+		if arg == "rollup" {
+			rp.markRollup(ctx.log, ctx.pr, ctx.user)
+		}
+	}
+}
+
+// minusCommand withdraws the user's approval.
+func minusCommand(rp *rplus, ctx commandContext, cmd command) {
+	rp.newMinus(ctx.log, ctx.pr, ctx.user)
+}
+
+// requestCommand asks the mentioned users to review the pull.
+func requestCommand(rp *rplus, ctx commandContext, cmd command) {
+	users := []string{}
+	for _, arg := range cmd.args {
+		if strings.HasPrefix(arg, "@") && len(arg) > 1 {
+			users = append(users, strings.TrimRight(arg[1:], ",."))
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+	rp.pMu.Lock()
+	o, present := rp.pending[ctx.pr]
+	author := present && o.author == ctx.user
+	rp.pMu.Unlock()
+	if !author && !rp.isReviewer(ctx.log, ctx.user) {
+		ctx.log.warn("ignoring review request from non-reviewer", "pr", ctx.pr, "user", ctx.user)
+		return
+	}
+	err := rp.requestReviewers(ctx.pr, users)
+	if err != nil {
+		ctx.log.error("failed to request reviews", "pr", ctx.pr, "err", err)
+	} else {
+		rp.assigned(ctx.pr, users)
+	}
+	err = rp.comment(ctx.pr, fmt.Sprintf("@%s: @%s has requested your review.", strings.Join(users, ", @"), ctx.user))
+	if err != nil {
+		ctx.log.error("failed to comment", "pr", ctx.pr, "err", err)
+	}
+}
+
+// statusCommand reports the current state of the pull, either by updating
+// the sticky bot comment or by replying with a summary.
+func statusCommand(rp *rplus, ctx commandContext, cmd command) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[ctx.pr]
+	if !present {
+		rp.ignored(ctx.log, ctx.pr, nil, "unknown-pull", ctx.user, "")
+		return
+	}
+	if rp.botComments {
+		rp.updateBotComment(ctx.log, ctx.pr, o)
+		return
+	}
+	summary, err := rp.summary(o)
+	if err != nil {
+		ctx.log.error("failed to render summary", "pr", ctx.pr, "err", err)
+		return
+	}
+	err = rp.comment(ctx.pr, summary)
+	if err != nil {
+		ctx.log.error("failed to comment", "pr", ctx.pr, "err", err)
+	}
+}
+
+// retryCommand re-posts the status of the current head of the pull, for
+// instance if a previous attempt failed, and lets the merge queue retry
+// merging it.
+func retryCommand(rp *rplus, ctx commandContext, cmd command) {
+	if !rp.isReviewer(ctx.log, ctx.user) {
+		ctx.log.warn("ignoring retry from non-reviewer", "pr", ctx.pr, "user", ctx.user)
+		return
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[ctx.pr]
+	if !present {
+		return
+	}
+	o.state, o.desc = "", ""
+	o.mergeFailed = ""
+	err := rp.reevaluate(ctx.log, ctx.pr, o)
+	if err != nil {
+		ctx.log.error("failed to update status", "pr", ctx.pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestParseCommands(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected []command
+	}{
+		{
+			name:     "no mention",
+			body:     "r+ looks good",
+			expected: []command{},
+		},
+		{
+			name:     "simple",
+			body:     "@rplus r+",
+			expected: []command{{name: "r+", args: []string{}}},
+		},
+		{
+			name:     "case and punctuation",
+			body:     "@RPlus: R+",
+			expected: []command{{name: "r+", args: []string{}}},
+		},
+		{
+			name:     "arguments",
+			body:     "@rplus r? @bob @carol",
+			expected: []command{{name: "r?", args: []string{"@bob", "@carol"}, text: "@bob @carol"}},
+		},
+		{
+			name: "surrounding text",
+			body: "Thanks for fixing this! @rplus r+ abc1234",
+			expected: []command{
+				{name: "r+", args: []string{"abc1234"}, text: "abc1234"},
+			},
+		},
+		{
+			name: "multiple mentions on one line",
+			body: "@rplus delegate+ @carol @rplus status",
+			expected: []command{
+				{name: "delegate+", args: []string{"@carol"}, text: "@carol"},
+				{name: "status", args: []string{}},
+			},
+		},
+		{
+			name: "multiple lines",
+			body: "Looks good.\r\n\r\n@rplus r+\n@rplus retry\n",
+			expected: []command{
+				{name: "r+", args: []string{}},
+				{name: "retry", args: []string{}},
+			},
+		},
+		{
+			name:     "mention without command",
+			body:     "ping @rplus",
+			expected: []command{},
+		},
+		{
+			name:     "other mention",
+			body:     "@rplusplus r+ @rplus-bot r+",
+			expected: []command{},
+		},
+		{
+			name:     "quoted",
+			body:     "> @rplus r+\nI don't think that's right",
+			expected: []command{},
+		},
+		{
+			name:     "indented quote",
+			body:     "  > @rplus r+",
+			expected: []command{},
+		},
+		{
+			name:     "code fence",
+			body:     "```\n@rplus r+\n```\n@rplus status",
+			expected: []command{{name: "status", args: []string{}}},
+		},
+		{
+			name:     "tilde fence containing backtick fence",
+			body:     "~~~\n```\n@rplus r+\n~~~\n@rplus r-",
+			expected: []command{{name: "r-", args: []string{}}},
+		},
+		{
+			name:     "unterminated fence",
+			body:     "```go\n@rplus r+",
+			expected: []command{},
+		},
+		{
+			name:     "inline code",
+			body:     "use `@rplus r+` to approve",
+			expected: []command{},
+		},
+		{
+			name:     "inline code arguments",
+			body:     "@rplus r+ `ignored` abc1234",
+			expected: []command{{name: "r+", args: []string{"abc1234"}, text: "abc1234"}},
+		},
+		{
+			name: "override",
+			body: "@rplus r+ override: site is  down",
+			expected: []command{
+				{name: "r+", args: []string{"override:", "site", "is", "down"}, text: "override: site is down"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		cmds := parseCommands(tc.body, "rplus")
+		if !reflect.DeepEqual(cmds, tc.expected) {
+			t.Errorf("%s: parseCommands(%q) returned %#v, expected %#v", tc.name, tc.body, cmds, tc.expected)
+		}
+	}
+
+	if cmds := parseCommands("@rplus r+", ""); len(cmds) != 0 {
+		t.Fatalf("parseCommands found commands without a bot name: %#v", cmds)
+	}
+}
+
+func TestPatternCommands(t *testing.T) {
+	rp := &rplus{reviewPattern: regexp.MustCompile(`r\+(?:\s+(?P<sha>[0-9a-f]{7,40}))?`)}
+	testCases := []struct {
+		body     string
+		expected []command
+	}{
+		{"lgtm", nil},
+		{"r+", []command{{name: "r+"}}},
+		{"r+ abc1234", []command{{name: "r+", args: []string{"abc1234"}, text: "abc1234"}}},
+		{"> r+\nwhy?", nil},
+		{"```\nr+\n```", nil},
+		{"r+ override: site is down", []command{{name: "r+", args: []string{"override:"}, text: "override: site is down"}}},
+	}
+	for _, tc := range testCases {
+		cmds := rp.patternCommands(tc.body)
+		if !reflect.DeepEqual(cmds, tc.expected) {
+			t.Errorf("patternCommands(%q) returned %#v, expected %#v", tc.body, cmds, tc.expected)
+		}
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	comments, assignees := []string{}, []string{}
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal comment: %s", err)
+		}
+		comments = append(comments, *comment.Body)
+	})
+	mux.HandleFunc("/repos/testing/repo/issues/1/assignees", func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Assignees []string }
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal assignees: %s", err)
+		}
+		assignees = request.Assignees
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		requiredReviews: 1,
+		botName:         "rplus",
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	statusPath := "/repos/testing/repo/statuses/hash"
+
+	rp.runCommands(commandContext{pr: 1, user: "alice", commentID: 1}, "r+")
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatal("review pattern used when it isn't configured")
+	}
+	rp.runCommands(commandContext{pr: 1, user: "alice", commentID: 1}, "@rplus r+")
+	if ta.hits[statusPath] != "success" || rp.pending[1].approvals["alice"] != 1 {
+		t.Fatalf("r+ command didn't approve pull: %s", ta.hits[statusPath])
+	}
+	rp.runCommands(commandContext{pr: 1, user: "bob", commentID: 2}, "@rplus r-")
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("r- command withdrew another reviewer's approval: %s", ta.hits[statusPath])
+	}
+	rp.runCommands(commandContext{pr: 1, user: "alice", commentID: 3}, "@rplus r-")
+	if ta.hits[statusPath] != "pending" || len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("r- command didn't withdraw approval: %s", ta.hits[statusPath])
+	}
+
+	delete(ta.hits, statusPath)
+	rp.runCommands(commandContext{pr: 1, user: "mallory", commentID: 4}, "@rplus retry")
+	if _, present := ta.hits[statusPath]; present {
+		t.Fatal("retry command from non-reviewer re-posted status")
+	}
+	rp.runCommands(commandContext{pr: 1, user: "alice", commentID: 4}, "@rplus retry")
+	if ta.hits[statusPath] != "pending" {
+		t.Fatalf("retry command didn't re-post status: %s", ta.hits[statusPath])
+	}
+
+	rp.runCommands(commandContext{pr: 1, user: "mallory", commentID: 5}, "@rplus r? @bob, @alice")
+	if len(assignees) != 0 || len(comments) != 0 {
+		t.Fatalf("r? command from non-reviewer assigned %v and commented %v", assignees, comments)
+	}
+	rp.runCommands(commandContext{pr: 1, user: "roland", commentID: 5}, "@rplus r? @bob, @alice")
+	if !reflect.DeepEqual(assignees, []string{"bob", "alice"}) {
+		t.Fatalf("r? command assigned incorrect users: %v", assignees)
+	}
+	if !reflect.DeepEqual(rp.pending[1].assigned, []string{"bob", "alice"}) {
+		t.Fatalf("r? command didn't record assigned users: %v", rp.pending[1].assigned)
+	}
+	if len(comments) != 1 || !strings.Contains(comments[0], "@bob, @alice: @roland has requested your review") {
+		t.Fatalf("r? command didn't mention requested reviewers: %v", comments)
+	}
+
+	rp.runCommands(commandContext{pr: 1, user: "roland", commentID: 6}, "@rplus status")
+	if len(comments) != 2 || !strings.Contains(comments[1], "No approvals yet") {
+		t.Fatalf("status command didn't reply with summary: %v", comments)
+	}
+
+	rp.runCommands(commandContext{pr: 1, user: "alice", commentID: 7}, "@rplus frobnicate")
+	if len(comments) != 2 || ta.hits[statusPath] != "pending" {
+		t.Fatal("unknown command had an effect")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configError lists the problems found in a configuration file.
+type configError struct {
+	path     string
+	problems []string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("invalid config file '%s': %s", e.path, strings.Join(e.problems, "; "))
+}
+
+// yamlKey returns the key a struct field is unmarshalled from, or "" if
+// it's ignored.
+func yamlKey(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	switch tag {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(f.Name)
+	}
+	return tag
+}
+
+// unknownKeys returns the keys in v, a value unmarshalled from YAML into
+// an interface{}, which don't correspond to a field of t.
+func unknownKeys(v interface{}, t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	unknown := []string{}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]reflect.Type, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			if key := yamlKey(t.Field(i)); key != "" {
+				fields[key] = t.Field(i).Type
+			}
+		}
+		for k, fv := range m {
+			key := fmt.Sprintf("%v", k)
+			ft, present := fields[key]
+			if !present {
+				unknown = append(unknown, prefix+key)
+				continue
+			}
+			unknown = append(unknown, unknownKeys(fv, ft, prefix+key+".")...)
+		}
+	case reflect.Map:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		for k, mv := range m {
+			unknown = append(unknown, unknownKeys(mv, t.Elem(), fmt.Sprintf("%s%v.", prefix, k))...)
+		}
+	case reflect.Slice:
+		s, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, sv := range s {
+			unknown = append(unknown, unknownKeys(sv, t.Elem(), fmt.Sprintf("%s%d.", prefix, i))...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// validPath returns true if path can be registered with the webhook
+// server.
+func validPath(path string) bool {
+	return strings.HasPrefix(path, "/")
+}
+
+// loopbackAddr returns true if addr only listens on a loopback interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validate returns the problems with the values of a configuration which
+// newRplus doesn't check.
+func (c config) validate() []string {
+	problems := []string{}
+	if parts := strings.Split(c.Repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		problems = append(problems, fmt.Sprintf("repo '%s' isn't of the form owner/name", c.Repo))
+	}
+	if c.WebhookServer.Secret == "" {
+		problems = append(problems, "webhook-server.secret must be set")
+	}
+	if c.RequiredReviews < 0 {
+		problems = append(problems, "required-reviews can't be negative")
+	}
+	// members of groups can approve too, so count everyone who can
+	reviewers := listToSet(c.Reviewers)
+	for _, members := range c.Groups {
+		for _, m := range members {
+			reviewers[m] = struct{}{}
+		}
+	}
+	if len(reviewers) > 0 && c.RequiredReviews > len(reviewers) {
+		problems = append(problems, fmt.Sprintf("required-reviews is %d but there are only %d reviewers", c.RequiredReviews, len(reviewers)))
+	}
+	labels := make([]string, 0, len(c.Labels))
+	for l := range c.Labels {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		if n := c.Labels[l].RequiredReviews; len(reviewers) > 0 && n > len(reviewers) {
+			problems = append(problems, fmt.Sprintf("labels.%s.required-reviews is %d but there are only %d reviewers", l, n, len(reviewers)))
+		}
+	}
+	// the admin API is served by the webhook server if admin.addr isn't set
+	adminKey, adminAddr := "admin.addr", c.Admin.Addr
+	if adminAddr == "" {
+		adminKey, adminAddr = "webhook-server.addr", c.WebhookServer.Addr
+	}
+	tls := c.WebhookServer.Cert != "" && c.WebhookServer.CertKey != ""
+	if c.Admin.Token != "" && !tls && !loopbackAddr(adminAddr) {
+		problems = append(problems, fmt.Sprintf("%s '%s' must be a loopback address when admin.token is set unless webhook-server.certificate and webhook-server.certificate-key are set", adminKey, adminAddr))
+	}
+	for _, p := range []struct {
+		key, path string
+		optional  bool
+	}{
+		{"webhook-server.pr-path", c.WebhookServer.PRPath, false},
+		{"webhook-server.comment-path", c.WebhookServer.CommentPath, false},
+		{"webhook-server.metrics-path", c.WebhookServer.MetricsPath, true},
+		{"admin.prefix", c.Admin.Prefix, true},
+		{"dashboard.path", c.Dashboard.Path, true},
+	} {
+		if (p.path != "" || !p.optional) && !validPath(p.path) {
+			problems = append(problems, fmt.Sprintf("%s '%s' must start with /", p.key, p.path))
+		} else if p.path == "/healthz" || p.path == "/readyz" {
+			problems = append(problems, fmt.Sprintf("%s '%s' is reserved for health checks", p.key, p.path))
+		}
+	}
+	return problems
+}
+
+// loadConfig reads and parses a configuration file, resolving references
+// to secrets and rejecting unknown keys and invalid values.
+func loadConfig(path string) (config, error) {
+	var c config
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("failed to read config file '%s': %s", path, err)
+	}
+	err = yaml.Unmarshal(contents, &c)
+	if err != nil {
+		return c, fmt.Errorf("failed to parse config file '%s': %s", path, err)
+	}
+	var raw interface{}
+	err = yaml.Unmarshal(contents, &raw)
+	if err != nil {
+		return c, fmt.Errorf("failed to parse config file '%s': %s", path, err)
+	}
+	problems := []string{}
+	for _, key := range unknownKeys(raw, reflect.TypeOf(c), "") {
+		problems = append(problems, fmt.Sprintf("unknown key '%s'", key))
+	}
+	problems = append(problems, c.resolveSecrets()...)
+	problems = append(problems, c.validate()...)
+	if len(problems) > 0 {
+		return c, &configError{path, problems}
+	}
+	return c, nil
+}
+
+// checkConfig writes every problem found in a configuration file to out,
+// returning an error if there were any.
+func checkConfig(path string, out io.Writer) error {
+	c, err := loadConfig(path)
+	problems := []string{}
+	if ce, ok := err.(*configError); ok {
+		problems = ce.problems
+	} else if err != nil {
+		return err
+	}
+	if _, err := newRplus(c); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) == 0 {
+		_, err = fmt.Fprintf(out, "config file '%s' is valid\n", path)
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	return errors.New("config file has problems")
+}
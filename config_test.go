@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.yml")
+	write := func(contents string) {
+		err := ioutil.WriteFile(configPath, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write config file: %s", err)
+		}
+	}
+
+	valid := `repo: testing/repo
+reviewers: [alice]
+groups:
+  security: [alice, bob]
+required-reviews: 2
+labels:
+  trivial:
+    required-reviews: 1
+webhook-server:
+  certificate: cert.pem
+  certificate-key: key.pem
+  pr-path: /pr
+  comment-path: /comment
+  secret: shhhh
+`
+	write(valid)
+	c, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %s", err)
+	}
+	if c.WebhookServer.Cert != "cert.pem" || c.Labels["trivial"].RequiredReviews != 1 {
+		t.Fatalf("loadConfig parsed incorrect config: %+v", c)
+	}
+	out := new(bytes.Buffer)
+	if err := checkConfig(configPath, out); err != nil {
+		t.Fatalf("checkConfig failed for valid config: %s: %s", err, out.String())
+	}
+
+	write(`repo: testing
+reviewers: [alice]
+required-reviews: 2
+labels:
+  trivial:
+    required-reviews: 3
+    reviews: 1
+assign:
+  strategy: bogus
+admin:
+  addr: :9000
+  token: token
+webhook-server:
+  cert: cert.pem
+  cert-key: key.pem
+  pr-path: pr
+  comment-path: /healthz
+`)
+	_, err = loadConfig(configPath)
+	ce, ok := err.(*configError)
+	if !ok {
+		t.Fatalf("loadConfig returned incorrect error for invalid config: %v", err)
+	}
+	expected := []string{
+		"unknown key 'labels.trivial.reviews'",
+		"unknown key 'webhook-server.cert'",
+		"unknown key 'webhook-server.cert-key'",
+		"repo 'testing' isn't of the form owner/name",
+		"webhook-server.secret must be set",
+		"required-reviews is 2 but there are only 1 reviewers",
+		"labels.trivial.required-reviews is 3 but there are only 1 reviewers",
+		"admin.addr ':9000' must be a loopback address when admin.token is set unless webhook-server.certificate and webhook-server.certificate-key are set",
+		"webhook-server.pr-path 'pr' must start with /",
+		"webhook-server.comment-path '/healthz' is reserved for health checks",
+	}
+	if !reflect.DeepEqual(ce.problems, expected) {
+		t.Fatalf("loadConfig found incorrect problems: %q", ce.problems)
+	}
+
+	out.Reset()
+	if err := checkConfig(configPath, out); err == nil {
+		t.Fatal("checkConfig didn't fail for invalid config")
+	}
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if len(lines) != len(expected)+1 || string(lines[len(lines)-1]) != "unknown assignment strategy 'bogus'" {
+		t.Fatalf("checkConfig printed incorrect problems:\n%s", out.String())
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:9000": true,
+		"[::1]:9000":     true,
+		"localhost:9000": true,
+		":9000":          false,
+		"0.0.0.0:9000":   false,
+		"10.0.0.1:9000":  false,
+		"bogus":          false,
+	} {
+		if loopbackAddr(addr) != expected {
+			t.Fatalf("loopbackAddr(%q) returned %t", addr, !expected)
+		}
+	}
+}
+
+func TestValidateAdminAddr(t *testing.T) {
+	var c config
+	c.Repo = "testing/repo"
+	c.WebhookServer.Addr = "0.0.0.0:3344"
+	c.WebhookServer.PRPath, c.WebhookServer.CommentPath = "/pr", "/comment"
+	c.WebhookServer.Secret = "shhhh"
+	if problems := c.validate(); len(problems) != 0 {
+		t.Fatalf("validate found problems without the admin API: %q", problems)
+	}
+	c.Admin.Token = "token"
+	expected := []string{"webhook-server.addr '0.0.0.0:3344' must be a loopback address when admin.token is set unless webhook-server.certificate and webhook-server.certificate-key are set"}
+	if problems := c.validate(); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("validate found incorrect problems for admin API on webhook server: %q", problems)
+	}
+	c.Admin.Addr = "127.0.0.1:3345"
+	if problems := c.validate(); len(problems) != 0 {
+		t.Fatalf("validate found problems with loopback admin.addr: %q", problems)
+	}
+	c.Admin.Addr = ""
+	c.WebhookServer.Cert, c.WebhookServer.CertKey = "cert.pem", "key.pem"
+	if problems := c.validate(); len(problems) != 0 {
+		t.Fatalf("validate found problems with TLS webhook server: %q", problems)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// dashboardConfig describes the HTML dashboard of tracked pulls, which is
+// disabled unless a path is set.
+type dashboardConfig struct {
+	// Path is the path the dashboard is served at by the webhook server.
+	Path string `yaml:"path" json:"path"`
+	// URL is the public URL of the dashboard, if set it's used as the
+	// target URL of the statuses r-plus posts.
+	URL string `yaml:"url" json:"url"`
+}
+
+// dashboardRow describes a single pull on the dashboard.
+type dashboardRow struct {
+	Number      int
+	Link        string
+	Author      string
+	Base        string
+	Head        string
+	Age         string
+	State       string
+	Description string
+	Approvals   []string
+	// outstanding assigned reviewers, groups which haven't approved yet,
+	// and the number of further approvals required
+	WaitingOn []string
+	Groups    []string
+	Remaining int
+}
+
+type dashboardData struct {
+	Repo     string
+	Reviewer string
+	Rows     []dashboardRow
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>r-plus: {{.Repo}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+.success { color: #28a745; } .pending { color: #dbab09; } .failure, .error { color: #cb2431; }
+</style>
+</head>
+<body>
+<h1>Pull requests tracked for {{.Repo}}</h1>
+<form method="get">
+<label>Reviewer <input name="reviewer" value="{{.Reviewer}}"></label>
+<input type="submit" value="Filter">
+</form>
+<table>
+<tr><th>Pull request</th><th>Author</th><th>Base</th><th>Age</th><th>Status</th><th>Approvals</th><th>Waiting on</th></tr>
+{{range .Rows}}<tr id="pr-{{.Number}}">
+<td><a href="{{.Link}}">#{{.Number}}</a> <code>{{.Head}}</code></td>
+<td>{{.Author}}</td>
+<td>{{.Base}}</td>
+<td>{{.Age}}</td>
+<td class="{{.State}}">{{.State}}: {{.Description}}</td>
+<td>{{range $i, $r := .Approvals}}{{if $i}}, {{end}}{{$r}}{{end}}</td>
+<td>{{range $i, $r := .WaitingOn}}{{if $i}}, {{end}}@{{$r}}{{end}}{{range .Groups}} group {{.}}{{end}}{{if .Remaining}} ({{.Remaining}} more reviews){{end}}</td>
+</tr>
+{{else}}<tr><td colspan="7">Nothing to review.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// age formats a duration coarsely, e.g. "3d 4h".
+func age(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd %dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", d/time.Hour, d%time.Hour/time.Minute)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// involves returns true if the user is assigned to or has approved a pull.
+func involves(o *pull, user string) bool {
+	if _, approved := o.approvals[user]; approved {
+		return true
+	}
+	for _, u := range o.assigned {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// dashboardRows returns the rows of the dashboard, optionally only those
+// involving reviewer or with the given head. rp.pMu must be held by the
+// caller.
+func (rp *rplus) dashboardRows(reviewer, head string) []dashboardRow {
+	now := rp.now()
+	rows := []dashboardRow{}
+	for _, pr := range rp.sortedPulls() {
+		o := rp.pending[pr]
+		if reviewer != "" && !involves(o, reviewer) {
+			continue
+		}
+		if head != "" && o.currentHash != head {
+			continue
+		}
+		state, desc := rp.evaluate(o)
+		approvals := make([]string, 0, len(o.approvals))
+		for r := range o.approvals {
+			approvals = append(approvals, r)
+		}
+		sort.Strings(approvals)
+		row := dashboardRow{
+			Number:      pr,
+			Link:        fmt.Sprintf("https://github.com/%s/pull/%d", rp.repo, pr),
+			Author:      o.author,
+			Base:        o.base,
+			Head:        shortHash(o.currentHash),
+			Age:         age(now.Sub(o.opened)),
+			State:       state,
+			Description: desc,
+			Approvals:   approvals,
+		}
+		if rp.needsReview(o) {
+			required, missing, _ := rp.requirements(o)
+			row.WaitingOn = outstanding(o)
+			row.Groups = missing
+			if len(o.approvals) < required {
+				row.Remaining = required - len(o.approvals)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// dashboardHandler serves the HTML dashboard. The "reviewer" parameter
+// only shows pulls the reviewer is assigned to or has approved, "head"
+// only shows the pull with that head, and "repo" must match the tracked
+// repository if given.
+func (rp *rplus) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if repo := q.Get("repo"); repo != "" && repo != rp.repo {
+		http.Error(w, "unknown repository", http.StatusNotFound)
+		return
+	}
+	rp.pMu.Lock()
+	data := dashboardData{Repo: rp.repo, Reviewer: q.Get("reviewer"), Rows: rp.dashboardRows(q.Get("reviewer"), q.Get("head"))}
+	rp.pMu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, data)
+	if err != nil {
+		rp.log.error("failed to render dashboard", "err", err)
+	}
+}
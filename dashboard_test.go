@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestAge(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		5 * time.Minute:               "5m",
+		2*time.Hour + 3*time.Minute:   "2h 3m",
+		50*time.Hour + 59*time.Minute: "2d 2h",
+	} {
+		if a := age(d); a != expected {
+			t.Fatalf("age(%s) returned %q, expected %q", d, a, expected)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	targets := make(map[string]string)
+	serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status github.StatusEvent
+		err := json.NewDecoder(r.Body).Decode(&status)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal status: %s", err)
+		}
+		if status.TargetURL != nil {
+			targets[r.URL.Path] = *status.TargetURL
+		}
+	}))
+	defer serv.Close()
+	apiBase = serv.URL
+
+	now := time.Date(2016, time.April, 1, 0, 0, 0, 0, time.UTC)
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		groups:          map[string]map[string]struct{}{"security": {"carol": struct{}{}}},
+		labelRules:      map[string]labelRule{"security": {RequiredReviews: 2, RequireGroups: []string{"security"}}},
+		requiredReviews: 1,
+		clock:           func() time.Time { return now },
+		dashboard:       dashboardConfig{Path: "/dashboard", URL: "https://rplus.example.com/dashboard"},
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.pending[1].assigned = []string{"alice", "bob"}
+	rp.newLabels(nil, 1, []string{"security"})
+	rp.newPlus(nil, 1, "alice", "", 0)
+	rp.newCommit(nil, 2, "other-hash", "<script>", "master", nil)
+	if targets["/repos/testing/repo/statuses/hash"] != "https://rplus.example.com/dashboard?head=hash" {
+		t.Fatalf("status has incorrect target URL: %q", targets["/repos/testing/repo/statuses/hash"])
+	}
+	now = now.Add(26 * time.Hour)
+
+	get := func(query string) string {
+		rec := httptest.NewRecorder()
+		rp.dashboardHandler(rec, httptest.NewRequest("GET", "/dashboard"+query, nil))
+		return rec.Body.String()
+	}
+	page := get("")
+	for _, s := range []string{
+		`<a href="https://github.com/testing/repo/pull/1">#1</a>`,
+		`<td>1d 2h</td>`,
+		`<td class="pending">pending: 1 of 2 required reviews</td>`,
+		`<td>alice</td>`,
+		`<td>@bob group security (1 more reviews)</td>`,
+		`&lt;script&gt;`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Fatalf("dashboard is missing %q:\n%s", s, page)
+		}
+	}
+	if strings.Contains(page, "<script>") {
+		t.Fatal("dashboard didn't escape author")
+	}
+
+	if page := get("?reviewer=bob"); !strings.Contains(page, `id="pr-1"`) || strings.Contains(page, `id="pr-2"`) {
+		t.Fatalf("dashboard didn't filter by reviewer:\n%s", page)
+	}
+	if page := get("?head=other-hash"); strings.Contains(page, `id="pr-1"`) || !strings.Contains(page, `id="pr-2"`) {
+		t.Fatalf("dashboard didn't filter by head:\n%s", page)
+	}
+	if page := get("?repo=other/repo"); strings.Contains(page, `id="pr-`) {
+		t.Fatalf("dashboard showed pulls for other repository:\n%s", page)
+	}
+}
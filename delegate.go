@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommand("delegate+", delegateCommand)
+	registerCommand("delegate-", undelegateCommand)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// delegateCommand lets the mentioned users, or the author of the pull if
+// nobody is mentioned, approve it as if they were reviewers.
+func delegateCommand(rp *rplus, ctx commandContext, cmd command) {
+	users := []string{}
+	for _, arg := range cmd.args {
+		if strings.HasPrefix(arg, "@") && len(arg) > 1 {
+			users = append(users, strings.TrimRight(arg[1:], ",."))
+		}
+	}
+	rp.delegate(ctx.log, ctx.pr, ctx.user, users)
+}
+
+// undelegateCommand revokes all delegations on the pull.
+func undelegateCommand(rp *rplus, ctx commandContext, cmd command) {
+	rp.undelegate(ctx.log, ctx.pr, ctx.user)
+}
+
+// delegate records that reviewer has delegated approval rights for the
+// pull to users, or to its author if users is empty. Delegations persist
+// across new commits until revoked.
+func (rp *rplus) delegate(l *logger, pr int, reviewer string, users []string) {
+	if !rp.isReviewer(l, reviewer) {
+		return
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		l.warn("received delegate+ on unknown pull", "pr", pr)
+		return
+	}
+	if len(users) == 0 {
+		users = []string{o.author}
+	}
+	if reviewer == o.author && !rp.selfReview {
+		// an author can't delegate to themselves to approve their own pull
+		allowed := []string{}
+		for _, u := range users {
+			if u != o.author {
+				allowed = append(allowed, u)
+			}
+		}
+		users = allowed
+	}
+	if len(users) == 0 {
+		return
+	}
+	if o.delegates == nil {
+		o.delegates = make(map[string]string)
+	}
+	for _, u := range users {
+		o.delegates[u] = reviewer
+	}
+	err := rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
+
+// undelegate revokes all delegations on the pull along with any approvals
+// made by delegates who wouldn't otherwise have been allowed to approve.
+func (rp *rplus) undelegate(l *logger, pr int, reviewer string) {
+	if !rp.isReviewer(l, reviewer) {
+		return
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present || len(o.delegates) == 0 {
+		return
+	}
+	for delegate := range o.delegates {
+		ownPull := delegate == o.author && !rp.selfReview
+		if _, approved := o.approvals[delegate]; approved && (ownPull || !rp.canReview(delegate)) {
+			delete(o.approvals, delegate)
+		}
+	}
+	o.delegates = nil
+	err := rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
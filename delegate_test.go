@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDelegation(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		botName:         "rplus",
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	statusPath := "/repos/testing/repo/statuses/hash"
+
+	rp.runCommands(commandContext{pr: 1, user: "roland"}, "@rplus delegate+")
+	if len(rp.pending[1].delegates) != 0 {
+		t.Fatal("delegation accepted from user who isn't a reviewer")
+	}
+	rp.runCommands(commandContext{pr: 1, user: "roland"}, "@rplus r+")
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatal("author approved their own pull without delegation")
+	}
+
+	rp.runCommands(commandContext{pr: 1, user: "alice"}, "@rplus delegate+")
+	if rp.pending[1].delegates["roland"] != "alice" {
+		t.Fatalf("delegation to author wasn't recorded: %v", rp.pending[1].delegates)
+	}
+	if !strings.Contains(rp.pending[1].desc, "delegated to roland") {
+		t.Fatalf("status description doesn't mention delegation: %s", rp.pending[1].desc)
+	}
+
+	// delegations survive new commits
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.runCommands(commandContext{pr: 1, user: "roland"}, "@rplus r+")
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("delegated approval wasn't counted: %s", ta.hits[statusPath])
+	}
+
+	rp.runCommands(commandContext{pr: 1, user: "alice"}, "@rplus delegate-")
+	if len(rp.pending[1].delegates) != 0 || len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("delegate- didn't revoke delegation and delegated approvals: %v %v", rp.pending[1].delegates, rp.pending[1].approvals)
+	}
+	if ta.hits[statusPath] != "pending" {
+		t.Fatalf("revoking delegation didn't re-evaluate status: %s", ta.hits[statusPath])
+	}
+
+	rp.runCommands(commandContext{pr: 1, user: "alice"}, "@rplus delegate+ @carol")
+	if _, present := rp.pending[1].delegates["roland"]; present {
+		t.Fatal("delegation to another user was given to the author")
+	}
+	rp.runCommands(commandContext{pr: 1, user: "carol"}, "@rplus r+")
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("approval delegated to another user wasn't counted: %s", ta.hits[statusPath])
+	}
+}
+
+func TestSelfDelegation(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		botName:         "rplus",
+	}
+	rp.newCommit(nil, 1, "hash", "alice", "master", nil)
+	statusPath := "/repos/testing/repo/statuses/hash"
+
+	// a reviewer can't get around self-review by delegating to themselves
+	rp.runCommands(commandContext{pr: 1, user: "alice"}, "@rplus delegate+")
+	rp.runCommands(commandContext{pr: 1, user: "alice"}, "@rplus delegate+ @alice")
+	if len(rp.pending[1].delegates) != 0 {
+		t.Fatalf("author delegated to themselves: %v", rp.pending[1].delegates)
+	}
+	rp.pending[1].delegates = map[string]string{"alice": "alice"}
+	rp.runCommands(commandContext{pr: 1, user: "alice"}, "@rplus r+")
+	if len(rp.pending[1].approvals) != 0 || ta.hits[statusPath] != "pending" {
+		t.Fatalf("self-delegated approval was counted: %v", rp.pending[1].approvals)
+	}
+}
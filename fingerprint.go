@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// fingerprint computes an identifier for the changes introduced by head
+// relative to base, similar to git patch-id. The changed and context lines
+// of each file are included but not the line numbers of each hunk, so
+// that rebasing a pull onto a new base without altering its diff results
+// in the same fingerprint while moving a change elsewhere doesn't.
+func (rp *rplus) fingerprint(base, head string) (string, error) {
+	owner, repo := rp.ownerRepo()
+	comparison, _, err := rp.gh().Repositories.CompareCommits(owner, repo, base, head)
+	if err != nil {
+		return "", err
+	}
+	return patchFingerprint(comparison.Files), nil
+}
+
+func patchFingerprint(files []github.CommitFile) string {
+	sorted := make([]github.CommitFile, 0, len(files))
+	for _, f := range files {
+		if f.Filename != nil {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.Sort(byFilename(sorted))
+
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "file %s\n", *f.Filename)
+		if f.Status != nil {
+			fmt.Fprintf(h, "status %s\n", *f.Status)
+		}
+		if f.Patch == nil {
+			// binary files have no patch so fall back to the blob hash
+			if f.SHA != nil {
+				fmt.Fprintf(h, "blob %s\n", *f.SHA)
+			}
+			continue
+		}
+		for _, line := range strings.Split(*f.Patch, "\n") {
+			if strings.HasPrefix(line, "@@") {
+				// drop the line numbers, keeping any section heading
+				if end := strings.Index(line[2:], "@@"); end >= 0 {
+					line = "@@" + line[2+end+2:]
+				}
+			}
+			fmt.Fprintln(h, line)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+type byFilename []github.CommitFile
+
+func (b byFilename) Len() int           { return len(b) }
+func (b byFilename) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byFilename) Less(i, j int) bool { return *b[i].Filename < *b[j].Filename }
+
+// carryOver copies the approvals of the previous head of a pull to
+// the new head if the changes they introduce are identical.
+// rp.pMu must be held by the caller.
+func (rp *rplus) carryOver(l *logger, pr int, old, o *pull, base string) {
+	fp, err := rp.fingerprint(base, o.currentHash)
+	if err != nil {
+		l.error("failed to fingerprint commit", "pr", pr, "sha", o.currentHash, "err", err)
+		return
+	}
+	o.fingerprint = fp
+	if old == nil || len(old.approvals) == 0 || old.fingerprint != fp || old.currentHash == o.currentHash {
+		return
+	}
+	o.approvals = old.approvals
+	o.carriedFrom = old.carriedFrom
+	if o.carriedFrom == "" {
+		o.carriedFrom = old.currentHash
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestPatchFingerprint(t *testing.T) {
+	a := []github.CommitFile{
+		{Filename: github.String("b.go"), Patch: github.String("@@ -1,3 +1,3 @@\n ctx\n-old\n+new")},
+		{Filename: github.String("a.go"), Patch: github.String("@@ -10,2 +10,3 @@\n+added")},
+	}
+	rebased := []github.CommitFile{
+		{Filename: github.String("a.go"), Patch: github.String("@@ -14,2 +14,3 @@\n+added")},
+		{Filename: github.String("b.go"), Patch: github.String("@@ -5,3 +5,3 @@\n ctx\n-old\n+new")},
+	}
+	moved := []github.CommitFile{
+		{Filename: github.String("a.go"), Patch: github.String("@@ -10,2 +10,3 @@\n+added")},
+		{Filename: github.String("b.go"), Patch: github.String("@@ -40,3 +40,3 @@\n other ctx\n-old\n+new")},
+	}
+	changed := []github.CommitFile{
+		{Filename: github.String("a.go"), Patch: github.String("@@ -10,2 +10,3 @@\n+added")},
+		{Filename: github.String("b.go"), Patch: github.String("@@ -1,3 +1,3 @@\n ctx\n-old\n+newer")},
+	}
+	if patchFingerprint(a) != patchFingerprint(rebased) {
+		t.Fatal("rebased patch produced a different fingerprint")
+	}
+	if patchFingerprint(a) == patchFingerprint(moved) {
+		t.Fatal("patch moved elsewhere in the file produced the same fingerprint")
+	}
+	if patchFingerprint(a) == patchFingerprint(changed) {
+		t.Fatal("changed patch produced the same fingerprint")
+	}
+}
+
+func TestCarryApprovals(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	patches := map[string]string{
+		"hash":         "@@ -1 +1 @@\n-old\n+new",
+		"rebased-hash": "@@ -3 +3 @@\n-old\n+new",
+		"changed-hash": "@@ -3 +3 @@\n-old\n+different",
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/compare/", func(w http.ResponseWriter, r *http.Request) {
+		head := r.URL.Path[strings.LastIndex(r.URL.Path, "...")+3:]
+		err := json.NewEncoder(w).Encode(github.CommitsComparison{
+			Files: []github.CommitFile{{Filename: github.String("main.go"), Patch: github.String(patches[head])}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal comparison: %s", err)
+		}
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"rolandshoemaker": struct{}{}},
+		requiredReviews: 1,
+		carryApprovals:  true,
+	}
+
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "rolandshoemaker", "", 0)
+	if ta.hits["/repos/testing/repo/statuses/hash"] != "success" {
+		t.Fatalf("newPlus sent incorrect status: %s", ta.hits["/repos/testing/repo/statuses/hash"])
+	}
+
+	rp.newCommit(nil, 1, "rebased-hash", "roland", "master", nil)
+	if ta.hits["/repos/testing/repo/statuses/rebased-hash"] != "success" {
+		t.Fatalf("approvals weren't carried across rebase: %s", ta.hits["/repos/testing/repo/statuses/rebased-hash"])
+	}
+	if rp.pending[1].carriedFrom != "hash" {
+		t.Fatalf("pull has incorrect carried from hash: %s", rp.pending[1].carriedFrom)
+	}
+	if !strings.Contains(rp.pending[1].desc, "approvals carried from hash") {
+		t.Fatalf("status description doesn't mention carried approvals: %s", rp.pending[1].desc)
+	}
+
+	rp.newCommit(nil, 1, "changed-hash", "roland", "master", nil)
+	if ta.hits["/repos/testing/repo/statuses/changed-hash"] != "pending" {
+		t.Fatalf("approvals were carried across a changed patch: %s", ta.hits["/repos/testing/repo/statuses/changed-hash"])
+	}
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("pull has non-zero reviews after patch changed: %d", len(rp.pending[1].approvals))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// readinessCheck is the result of one of the checks made by the
+// readiness endpoint.
+type readinessCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// githubCheck is the result of checking the GitHub API token and rate
+// limit.
+type githubCheck struct {
+	readinessCheck
+	Limit     int        `json:"limit,omitempty"`
+	Remaining int        `json:"remaining"`
+	Reset     *time.Time `json:"reset,omitempty"`
+}
+
+type readiness struct {
+	Ready        bool           `json:"ready"`
+	Config       readinessCheck `json:"config"`
+	ConfigLoaded *time.Time     `json:"config-loaded,omitempty"`
+	State        readinessCheck `json:"state"`
+	GitHub       githubCheck    `json:"github"`
+	LastWebhook  *time.Time     `json:"last-webhook,omitempty"`
+}
+
+// webhookReceived records that a webhook with a valid signature was
+// received.
+func (rp *rplus) webhookReceived() {
+	rp.hMu.Lock()
+	defer rp.hMu.Unlock()
+	rp.lastWebhook = rp.now()
+}
+
+// healthHandler reports that the process is alive.
+func (rp *rplus) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// checkGitHub checks that the access token is accepted by GitHub and that
+// its rate limit isn't exhausted. Requests for the rate limit don't count
+// against it.
+func (rp *rplus) checkGitHub() githubCheck {
+	limits, _, err := rp.gh().RateLimits()
+	if err != nil {
+		return githubCheck{readinessCheck: readinessCheck{Error: err.Error()}}
+	}
+	if limits == nil || limits.Core == nil {
+		return githubCheck{readinessCheck: readinessCheck{Error: "no rate limit in response"}}
+	}
+	check := githubCheck{
+		readinessCheck: readinessCheck{OK: limits.Core.Remaining > 0},
+		Limit:          limits.Core.Limit,
+		Remaining:      limits.Core.Remaining,
+	}
+	if !limits.Core.Reset.IsZero() {
+		reset := limits.Core.Reset.UTC()
+		check.Reset = &reset
+	}
+	if !check.OK {
+		check.Error = "rate limit exhausted"
+	}
+	return check
+}
+
+// readyHandler reports whether r-plus is ready to handle webhooks: its
+// config is loaded, the last write of the state file succeeded, and the
+// GitHub API can be used. It responds 503 if any of those aren't true.
+func (rp *rplus) readyHandler(w http.ResponseWriter, r *http.Request) {
+	rp.confMu.RLock()
+	defer rp.confMu.RUnlock()
+	var status readiness
+	if rp.loaded.IsZero() {
+		status.Config.Error = "config hasn't been loaded"
+	} else {
+		status.Config.OK = true
+		loaded := rp.loaded.UTC()
+		status.ConfigLoaded = &loaded
+	}
+
+	rp.stMu.Lock()
+	stateErr := rp.stateErr
+	rp.stMu.Unlock()
+	if stateErr != nil {
+		status.State.Error = stateErr.Error()
+	} else {
+		status.State.OK = true
+	}
+
+	status.GitHub = rp.checkGitHub()
+
+	rp.hMu.Lock()
+	if !rp.lastWebhook.IsZero() {
+		last := rp.lastWebhook.UTC()
+		status.LastWebhook = &last
+	}
+	rp.hMu.Unlock()
+
+	status.Ready = status.Config.OK && status.State.OK && status.GitHub.OK
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+		rp.log.warn("not ready", "config", status.Config.Error, "state", status.State.Error, "github", status.GitHub.Error)
+	}
+	writeJSON(w, code, status)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	remaining, code := 4000, http.StatusOK
+	serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rate_limit" {
+			t.Fatalf("readiness check requested %s", r.URL.Path)
+		}
+		w.WriteHeader(code)
+		if code != http.StatusOK {
+			fmt.Fprint(w, `{"message": "Bad credentials"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"resources": {"core": {"limit": 5000, "remaining": %d, "reset": 1459468800}}}`, remaining)
+	}))
+	defer serv.Close()
+	apiBase = serv.URL
+
+	now := time.Date(2016, time.April, 1, 0, 0, 0, 0, time.UTC)
+	rp := &rplus{
+		pending: make(map[int]*pull),
+		client:  new(http.Client),
+		repo:    "testing/repo",
+		loaded:  now,
+		clock:   func() time.Time { return now },
+	}
+	ready := func() (int, readiness) {
+		rec := httptest.NewRecorder()
+		rp.readyHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var status readiness
+		err := json.Unmarshal(rec.Body.Bytes(), &status)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal readiness: %s", err)
+		}
+		return rec.Code, status
+	}
+
+	rec := httptest.NewRecorder()
+	rp.healthHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthz returned %d", rec.Code)
+	}
+
+	rp.webhookReceived()
+	c, status := ready()
+	if c != http.StatusOK || !status.Ready || status.GitHub.Remaining != 4000 || status.GitHub.Limit != 5000 {
+		t.Fatalf("readyz returned %d: %+v", c, status)
+	}
+	if status.LastWebhook == nil || !status.LastWebhook.Equal(now) {
+		t.Fatalf("readyz returned incorrect last webhook: %v", status.LastWebhook)
+	}
+
+	remaining = 0
+	if c, status := ready(); c != http.StatusServiceUnavailable || status.GitHub.OK || status.GitHub.Error != "rate limit exhausted" {
+		t.Fatalf("readyz with exhausted rate limit returned %d: %+v", c, status)
+	}
+
+	remaining, code = 4000, http.StatusUnauthorized
+	if c, status := ready(); c != http.StatusServiceUnavailable || status.GitHub.OK || status.GitHub.Error == "" {
+		t.Fatalf("readyz with invalid token returned %d: %+v", c, status)
+	}
+
+	code = http.StatusOK
+	rp.stateErr = errors.New("disk full")
+	if c, status := ready(); c != http.StatusServiceUnavailable || status.State.OK || status.State.Error != "disk full" {
+		t.Fatalf("readyz with failing state file returned %d: %+v", c, status)
+	}
+	rp.stateErr = nil
+	rp.loaded = time.Time{}
+	if c, status := ready(); c != http.StatusServiceUnavailable || status.Config.OK {
+		t.Fatalf("readyz without config returned %d: %+v", c, status)
+	}
+
+	// readiness doesn't wait for pulls being processed
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		rp.readyHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readyz waited for rp.pMu")
+	}
+}
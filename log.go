@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logConfig describes how diagnostics are logged.
+type logConfig struct {
+	// Format is either "logfmt", the default, or "json".
+	Format string `yaml:"format"`
+	// Level is the minimum level logged, one of "debug", "info" (the
+	// default), "warn", or "error".
+	Level string `yaml:"level"`
+}
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+func (l logLevel) String() string {
+	for name, level := range logLevels {
+		if level == l {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+var logFormats = map[string]struct{}{
+	"logfmt": struct{}{},
+	"json":   struct{}{},
+}
+
+// redactedFields lists the fields whose values are never logged.
+var redactedFields = map[string]struct{}{
+	"secret":       struct{}{},
+	"access-token": struct{}{},
+	"expected-mac": struct{}{},
+}
+
+// logger writes structured log lines made up of a level, a message, and
+// fields given as alternating keys and values. A nil logger writes to
+// stderr in the default format.
+type logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	json   bool
+	level  logLevel
+	fields []interface{}
+	clock  func() time.Time
+}
+
+func newLogger(out io.Writer, format string, level logLevel) *logger {
+	return &logger{mu: new(sync.Mutex), out: out, json: format == "json", level: level, clock: time.Now}
+}
+
+var defaultLogger = newLogger(os.Stderr, "logfmt", levelInfo)
+
+// with returns a logger which adds the given fields to every line.
+func (l *logger) with(kv ...interface{}) *logger {
+	if l == nil {
+		l = defaultLogger
+	}
+	child := *l
+	child.fields = append(append([]interface{}{}, l.fields...), kv...)
+	return &child
+}
+
+func (l *logger) debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *logger) info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *logger) warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *logger) error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+func (l *logger) log(level logLevel, msg string, kv []interface{}) {
+	if l == nil {
+		l = defaultLogger
+	}
+	if level < l.level {
+		return
+	}
+	fields := []interface{}{
+		"time", l.clock().UTC().Format(time.RFC3339),
+		"level", level.String(),
+		"msg", msg,
+	}
+	fields = append(append(fields, l.fields...), kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+	buf := new(bytes.Buffer)
+	if l.json {
+		buf.WriteByte('{')
+	}
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		value := fields[i+1]
+		if _, redact := redactedFields[key]; redact {
+			value = "[redacted]"
+		}
+		if l.json {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(jsonValue(key))
+			buf.WriteByte(':')
+			buf.WriteString(jsonValue(value))
+			continue
+		}
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(value))
+	}
+	if l.json {
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func jsonValue(v interface{}) string {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return string(data)
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case error:
+		s = t.Error()
+	default:
+		s = fmt.Sprint(t)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
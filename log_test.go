@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogger(format string, level logLevel) (*logger, *bytes.Buffer) {
+	buf := new(bytes.Buffer)
+	l := newLogger(buf, format, level)
+	l.clock = func() time.Time { return time.Date(2016, time.April, 1, 0, 0, 0, 0, time.UTC) }
+	return l, buf
+}
+
+func TestLogfmt(t *testing.T) {
+	l, buf := testLogger("logfmt", levelInfo)
+	l = l.with("repo", "testing/repo")
+	l.debug("hidden")
+	l.info("posted status", "pr", 1, "err", errors.New("bad thing"), "secret", "shhhh", "empty", "")
+	expected := `time=2016-04-01T00:00:00Z level=info msg="posted status" repo=testing/repo pr=1 err="bad thing" secret=[redacted] empty=""` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("logger wrote incorrect line:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestJSONLog(t *testing.T) {
+	l, buf := testLogger("json", levelWarn)
+	l.info("hidden")
+	l.with("delivery", "abc").error("failed", "pr", 2, "expected-mac", []byte{1, 2})
+	var line map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("logger wrote invalid JSON %q: %s", buf.String(), err)
+	}
+	if line["level"] != "error" || line["msg"] != "failed" || line["delivery"] != "abc" || line["pr"] != float64(2) {
+		t.Fatalf("logger wrote incorrect fields: %v", line)
+	}
+	if line["expected-mac"] != "[redacted]" {
+		t.Fatalf("logger didn't redact expected MAC: %v", line["expected-mac"])
+	}
+}
+
+func TestVerifiedHandlerOmitsMAC(t *testing.T) {
+	l, buf := testLogger("logfmt", levelDebug)
+	rp := &rplus{secret: []byte("secret"), log: l}
+	h := rp.verifiedHandler(func(l *logger, b []byte, w http.ResponseWriter) {
+		t.Fatal("handler called with invalid signature")
+	})
+	req := httptest.NewRequest("POST", "/wh/pr", strings.NewReader("body"))
+	req.Header.Set("X-Hub-Signature", "sha1=00")
+	req.Header.Set("X-GitHub-Delivery", "delivery-id")
+	h(httptest.NewRecorder(), req)
+
+	mac := hmac.New(sha1.New, rp.secret)
+	mac.Write([]byte("body"))
+	expected := mac.Sum(nil)
+	if strings.Contains(buf.String(), hex.EncodeToString(expected)) || strings.Contains(buf.String(), string(expected)) || strings.Contains(buf.String(), "expected-mac") {
+		t.Fatalf("verifiedHandler logged the expected MAC: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "delivery=delivery-id") {
+		t.Fatalf("verifiedHandler didn't log the delivery ID: %s", buf.String())
+	}
+}
+
+func TestStatusLogIncludesDelivery(t *testing.T) {
+	serv := httptest.NewServer(&testAPI{hits: make(map[string]string), t: t})
+	defer serv.Close()
+	apiBase = serv.URL
+
+	l, buf := testLogger("logfmt", levelDebug)
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		requiredReviews: 1,
+		assign:          assignConfig{Strategy: "round-robin"},
+		log:             l,
+	}
+	body := []byte(`{"action": "opened", "number": 1, "pull_request": {"head": {"sha": "hash"}, "user": {"login": "roland"}}}`)
+	rp.prHandler(l.with("delivery", "delivery-id"), body, httptest.NewRecorder())
+	if !strings.Contains(buf.String(), `msg="no reviewers available to assign" delivery=delivery-id`) {
+		t.Fatalf("assignment line is missing the delivery ID: %s", buf.String())
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "msg=\"posted status\"") {
+			if !strings.Contains(line, "delivery=delivery-id") || !strings.Contains(line, "pr=1") || !strings.Contains(line, "sha=hash") {
+				t.Fatalf("status line is missing the delivery ID, pull, or SHA: %s", line)
+			}
+			return
+		}
+	}
+	t.Fatalf("no status line logged: %s", buf.String())
+}
@@ -2,88 +2,330 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
-	"gopkg.in/yaml.v2"
 )
 
 type pull struct {
 	currentHash string
 	author      string
-	reviews     int
+	base        string
+	approvals   map[string]int // reviewer -> ID of the approving comment
+	labels      map[string]struct{}
+
+	// when the pull was first seen, when currentHash was pushed, and when
+	// a reminder was last posted
+	opened   time.Time
+	pushed   time.Time
+	reminded time.Time
+
+	// patch fingerprint of currentHash and the commit approvals were
+	// originally given to if they were carried across a rebase
+	fingerprint string
+	carriedFrom string
+
+	// user who overrode the review policy for currentHash and why
+	overrideBy     string
+	overrideReason string
+
+	// last status posted for currentHash
+	state string
+	desc  string
+
+	// merge queue priority and the head which failed to merge, if any
+	priority    int
+	mergeFailed string
+	// merge commit on the auto branch being tested, if any
+	testMerge string
+	// whether the pull can be merged in a batch of rollups, and the batch
+	// it's currently part of
+	rollup bool
+	batch  int
+
+	// users who were asked to review the pull
+	assigned []string
+
+	// users allowed to approve by delegation -> reviewer who delegated
+	delegates map[string]string
+
+	// ID of the sticky bot comment and the latest explanation of why an
+	// approval was ignored
+	botComment int
+	note       string
 }
 
 type rplus struct {
-	requiredReviews int
-	reviewers       map[string]struct{}
-	reviewPattern   *regexp.Regexp
-	selfReview      bool
-	repo            string // username/project
-	secret          []byte
+	requiredReviews    int
+	reviewers          map[string]struct{}
+	groups             map[string]map[string]struct{}
+	labelRules         map[string]labelRule
+	reviewPattern      *regexp.Regexp
+	botName            string
+	selfReview         bool
+	countEdited        bool
+	botComments        bool
+	assign             assignConfig
+	nextReviewer       int
+	remindAfter        time.Duration
+	remindInterval     time.Duration
+	digestIssue        int
+	digestHour         int
+	lastDigest         time.Time
+	queue              queueConfig
+	queueInterval      time.Duration
+	admin              adminConfig
+	dashboard          dashboardConfig
+	templates          map[string]*template.Template
+	checkCollaborators bool
+	collaboratorTTL    time.Duration
+	overriders         map[string]struct{}
+	overrideIssues     bool
+	auditLog           string
+	carryApprovals     bool
+	minOpen            time.Duration
+	skipWeekends       bool
+	repo               string // username/project
+	secret             []byte
+	stateFile          string
+	conf               config
+	configPoll         time.Duration
+	loaded             time.Time // when conf was loaded
+
+	// confMu guards the settings which can be reloaded. Webhook and admin
+	// requests and each round of the background loops hold it for reading,
+	// and reloading holds it for writing along with pMu, so settings read
+	// with either held are consistent.
+	confMu sync.RWMutex
 
 	pending map[int]*pull
+	timers  map[int]*time.Timer
 	pMu     sync.Mutex
+	clock   func() time.Time
+	log     *logger
+
+	// stateErr is the result of the last write of the state file and
+	// tracked is the number of pulls in pending as of then, both guarded
+	// by stMu so readiness checks and metrics don't wait on pMu
+	stateErr error
+	tracked  int
+	stMu     sync.Mutex
+
+	collaborators map[string]cachedPermission
+	cMu           sync.Mutex
+
+	// lastWebhook is when the last webhook with a valid signature was
+	// received, and is guarded by hMu
+	lastWebhook time.Time
+	hMu         sync.Mutex
+
+	// stop is closed when shutdown starts, servers are the running HTTP
+	// servers and are guarded by sMu, and loops tracks the background
+	// loops
+	stop    chan struct{}
+	servers []*http.Server
+	sMu     sync.Mutex
+	loops   sync.WaitGroup
 
 	client *http.Client
 }
 
-func (rp *rplus) newCommit(pr int, hash, author string) {
+// newCommit starts tracking a new head of a pull. If labels is nil the
+// labels already tracked for the pull are kept.
+func (rp *rplus) newCommit(l *logger, pr int, hash, author, base string, labels []string) {
 	rp.pMu.Lock()
 	defer rp.pMu.Unlock()
-	rp.pending[pr] = &pull{currentHash: hash, author: author}
-	err := rp.updateStatus(hash, "pending")
+	o := &pull{currentHash: hash, author: author, base: base, approvals: make(map[string]int), opened: rp.now(), pushed: rp.now()}
+	old := rp.pending[pr]
+	if old != nil {
+		o.labels = old.labels
+		o.opened = old.opened
+		o.botComment = old.botComment
+		o.delegates = old.delegates
+		o.assigned = old.assigned
+		o.priority = old.priority
+		if old.testMerge != "" {
+			rp.abandonTestMerge(old.testMerge)
+		}
+	}
+	if labels != nil {
+		o.labels = listToSet(labels)
+	}
+	if rp.carryApprovals {
+		rp.carryOver(l, pr, old, o, base)
+	}
+	rp.pending[pr] = o
+	err := rp.reevaluate(l, pr, o)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to update status for commit '%s' on #%d: %s\n", hash, pr, err)
+		l.error("failed to update status", "pr", pr, "sha", hash, "err", err)
 	}
+	rp.saveState()
 }
 
-func (rp *rplus) newPlus(pr int, reviewer string) {
-	if _, present := rp.reviewers[reviewer]; !present {
+// newPlus records an approval from reviewer made in the comment with the
+// given ID. If sha is not empty the approval only applies if it is a
+// prefix of the current head of the pull.
+func (rp *rplus) newPlus(l *logger, pr int, reviewer, sha string, commentID int) {
+	isReviewer := rp.isReviewer(l, reviewer)
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	delegated, delegatedBy := false, ""
+	if present {
+		delegatedBy, delegated = o.delegates[reviewer]
+	}
+	if !isReviewer && !delegated {
+		if present {
+			rp.ignored(l, pr, o, "not-reviewer", reviewer, sha)
+		}
+		return
+	}
+	if !present {
+		l.warn("received r+ on unknown pull", "pr", pr)
+		rp.ignored(l, pr, nil, "unknown-pull", reviewer, sha)
+		return
+	}
+	if !rp.selfReview && o.author == reviewer && (!delegated || delegatedBy == reviewer) {
+		rp.ignored(l, pr, o, "self-review", reviewer, sha)
 		return
 	}
+	if sha != "" && !strings.HasPrefix(o.currentHash, strings.ToLower(sha)) {
+		rp.ignored(l, pr, o, "stale-commit", reviewer, sha)
+		return
+	}
+	o.approvals[reviewer] = commentID
+	approvalsTotal.inc(reviewer)
+	o.note = ""
+	err := rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
+
+// newMinus withdraws any approval reviewer has given to the pull.
+func (rp *rplus) newMinus(l *logger, pr int, reviewer string) {
 	rp.pMu.Lock()
 	defer rp.pMu.Unlock()
-	if _, present := rp.pending[pr]; !present {
-		fmt.Fprintf(os.Stderr, "Received r+ on PR I don't know about: #%d\n", pr)
+	o, present := rp.pending[pr]
+	if !present {
 		return
 	}
-	o := rp.pending[pr]
-	if !rp.selfReview && o.author == reviewer {
+	if _, approved := o.approvals[reviewer]; !approved {
 		return
 	}
-	o.reviews++
-	if o.reviews >= rp.requiredReviews {
-		err := rp.updateStatus(o.currentHash, "success")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to update status for commit '%s' on #%d: %s\n", o.currentHash, pr, err)
-			return
+	delete(o.approvals, reviewer)
+	err := rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
+
+// revokeComment removes any approval made by the comment with the given
+// ID, for instance because it was edited or deleted.
+func (rp *rplus) revokeComment(l *logger, pr int, commentID int) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		return
+	}
+	revoked := false
+	for reviewer, id := range o.approvals {
+		if id == commentID {
+			delete(o.approvals, reviewer)
+			revoked = true
 		}
-		delete(rp.pending, pr)
 	}
+	if !revoked {
+		return
+	}
+	err := rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
+
+func (rp *rplus) closed(l *logger, pr int) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	if t, present := rp.timers[pr]; present {
+		t.Stop()
+		delete(rp.timers, pr)
+	}
+	if o, present := rp.pending[pr]; present && o.testMerge != "" {
+		rp.abandonTestMerge(o.testMerge)
+	}
+	delete(rp.pending, pr)
+	rp.saveState()
 }
 
 var (
-	apiBase    = "https://api.github.com"
-	statusDesc = ""
-	statusCtx  = "github/reviews"
+	apiBase   = "https://api.github.com"
+	statusCtx = "github/reviews"
 )
 
-func (rp *rplus) updateStatus(hash string, state string) error {
+// gh returns a GitHub API client which talks to apiBase.
+func (rp *rplus) gh() *github.Client {
+	c := github.NewClient(rp.client)
+	base, err := url.Parse(apiBase + "/")
+	if err == nil {
+		c.BaseURL = base
+	}
+	return c
+}
+
+// ownerRepo splits rp.repo into the owner and project names.
+func (rp *rplus) ownerRepo() (string, string) {
+	parts := strings.SplitN(rp.repo, "/", 2)
+	if len(parts) != 2 {
+		return rp.repo, ""
+	}
+	return parts[0], parts[1]
+}
+
+// maxDescLen is the longest status description GitHub will accept.
+const maxDescLen = 140
+
+func (rp *rplus) updateStatus(l *logger, pr int, hash, state, desc string) (err error) {
+	defer func() {
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		statusesPosted.inc(state, outcome)
+		l.debug("posted status", "pr", pr, "sha", hash, "state", state, "err", err)
+	}()
+	if len(desc) > maxDescLen {
+		desc = desc[:maxDescLen-3] + "..."
+	}
 	status := github.StatusEvent{
 		State:       &state,
-		Description: &statusDesc,
+		Description: &desc,
 		Context:     &statusCtx,
 	}
+	if rp.dashboard.URL != "" {
+		target := fmt.Sprintf("%s?head=%s", rp.dashboard.URL, url.QueryEscape(hash))
+		status.TargetURL = &target
+	}
 	data, err := json.Marshal(status)
 	if err != nil {
 		return err
@@ -97,7 +339,9 @@ func (rp *rplus) updateStatus(hash string, state string) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
 	resp, err := rp.client.Do(req)
+	githubLatency.observe(time.Since(start).Seconds())
 	if err != nil {
 		return err
 	}
@@ -112,77 +356,291 @@ func (rp *rplus) updateStatus(hash string, state string) error {
 	return fmt.Errorf("unexpected response status code, body: %s", strings.Replace(string(content), "\n", "", -1))
 }
 
-func (rp *rplus) run(webhookAddr, certPath, keyPath, prPath, commentPath string) error {
-	http.HandleFunc(prPath, rp.verifiedHandler(rp.prHandler))
-	http.HandleFunc(commentPath, rp.verifiedHandler(rp.commentHandler))
-	if certPath != "" && keyPath != "" {
-		return http.ListenAndServeTLS(webhookAddr, certPath, keyPath, nil)
+func (rp *rplus) comment(pr int, body string) error {
+	owner, repo := rp.ownerRepo()
+	_, _, err := rp.gh().Issues.CreateComment(owner, repo, pr, &github.IssueComment{Body: &body})
+	return err
+}
+
+func (rp *rplus) run(webhookAddr, certPath, keyPath, prPath, commentPath, metricsPath string) error {
+	rp.goLoop(func() { rp.remindLoop(time.Minute) })
+	if rp.queue.Enabled {
+		rp.goLoop(func() { rp.queueLoop(rp.queueInterval) })
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(prPath, rp.verifiedHandler(rp.prHandler))
+	mux.HandleFunc(commentPath, rp.verifiedHandler(rp.commentHandler))
+	mux.HandleFunc(metricsPath, rp.metricsHandler)
+	mux.HandleFunc("/healthz", rp.healthHandler)
+	mux.HandleFunc("/readyz", rp.readyHandler)
+	rp.serveAdmin(mux, certPath, keyPath)
+	if rp.dashboard.Path != "" {
+		mux.HandleFunc(rp.dashboard.Path, rp.dashboardHandler)
 	}
-	return http.ListenAndServe(webhookAddr, nil)
+	return rp.serve(&http.Server{Addr: webhookAddr, Handler: mux}, certPath, keyPath)
 }
 
 type config struct {
-	Reviewers       []string
-	RequiredReviews int    `yaml:"required-reviews"`
-	ReviewPattern   string `yaml:"review-pattern"`
-	SelfReview      bool   `yaml:"self-review"`
-	Repo            string `yaml:"repo"`
-	AccessToken     string `yaml:"access-token"`
-	WebhookServer   struct {
+	Reviewers          []string
+	RequiredReviews    int                  `yaml:"required-reviews"`
+	ReviewPattern      string               `yaml:"review-pattern"`
+	BotName            string               `yaml:"bot-name"`
+	SelfReview         bool                 `yaml:"self-review"`
+	CountEdited        bool                 `yaml:"count-edited-comments"`
+	BotComment         bool                 `yaml:"bot-comment"`
+	Assign             assignConfig         `yaml:"assign"`
+	Reminders          remindConfig         `yaml:"reminders"`
+	MergeQueue         queueConfig          `yaml:"merge-queue"`
+	Log                logConfig            `yaml:"log"`
+	Admin              adminConfig          `yaml:"admin"`
+	Dashboard          dashboardConfig      `yaml:"dashboard"`
+	Templates          map[string]string    `yaml:"templates"`
+	CheckCollaborators bool                 `yaml:"check-collaborators"`
+	CollaboratorTTL    string               `yaml:"collaborator-cache-ttl"`
+	Overriders         []string             `yaml:"overriders"`
+	OverrideIssues     bool                 `yaml:"override-issues"`
+	AuditLog           string               `yaml:"audit-log"`
+	CarryApprovals     bool                 `yaml:"carry-approvals"`
+	MinOpenDuration    string               `yaml:"min-open-duration"`
+	SkipWeekends       bool                 `yaml:"min-open-skip-weekends"`
+	StateFile          string               `yaml:"state-file"`
+	ConfigPoll         string               `yaml:"config-poll-interval"`
+	Repo               string               `yaml:"repo"`
+	Groups             map[string][]string  `yaml:"groups"`
+	Labels             map[string]labelRule `yaml:"labels"`
+	AccessToken        string               `yaml:"access-token"`
+	WebhookServer      struct {
 		Addr        string `yaml:"addr"`
 		Cert        string `yaml:"certificate"`
 		CertKey     string `yaml:"certificate-key"`
 		PRPath      string `yaml:"pr-path"`
 		CommentPath string `yaml:"comment-path"`
+		MetricsPath string `yaml:"metrics-path"`
 		Secret      string `yaml:"secret"`
 	} `yaml:"webhook-server"`
 }
 
+// newRplus validates a configuration and returns an rplus which uses it,
+// with no pull requests tracked.
+func newRplus(c config) (*rplus, error) {
+	var err error
+	reviewerMap := make(map[string]struct{}, len(c.Reviewers))
+	for _, r := range c.Reviewers {
+		reviewerMap[r] = struct{}{}
+	}
+	overriders := make(map[string]struct{}, len(c.Overriders))
+	for _, o := range c.Overriders {
+		overriders[o] = struct{}{}
+	}
+	if len(overriders) > 0 && c.AuditLog == "" {
+		return nil, errors.New("an audit log is required when overriders are configured")
+	}
+	groups := make(map[string]map[string]struct{}, len(c.Groups))
+	for name, members := range c.Groups {
+		groups[name] = make(map[string]struct{}, len(members))
+		for _, m := range members {
+			groups[name][m] = struct{}{}
+		}
+	}
+	for l, rule := range c.Labels {
+		for _, g := range rule.RequireGroups {
+			if _, present := groups[g]; !present {
+				return nil, fmt.Errorf("label '%s' requires unknown group '%s'", l, g)
+			}
+		}
+	}
+	if c.Assign.Strategy != "" {
+		if _, present := assignStrategies[c.Assign.Strategy]; !present {
+			return nil, fmt.Errorf("unknown assignment strategy '%s'", c.Assign.Strategy)
+		}
+		if c.Assign.Strategy == "codeowners" && c.Assign.CodeOwners == "" {
+			return nil, errors.New("the codeowners assignment strategy requires the path of a CODEOWNERS file")
+		}
+	}
+	templates, err := parseTemplates(c.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse templates: %s", err)
+	}
+	var reviewPattern *regexp.Regexp
+	if c.ReviewPattern != "" {
+		reviewPattern, err = regexp.Compile(c.ReviewPattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile review pattern: %s", err)
+		}
+	}
+	var minOpen time.Duration
+	if c.MinOpenDuration != "" {
+		minOpen, err = time.ParseDuration(c.MinOpenDuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse minimum open duration: %s", err)
+		}
+	}
+	collaboratorTTL := 10 * time.Minute
+	if c.CollaboratorTTL != "" {
+		collaboratorTTL, err = time.ParseDuration(c.CollaboratorTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse collaborator cache TTL: %s", err)
+		}
+	}
+	var remindAfter, remindInterval time.Duration
+	if c.Reminders.After != "" {
+		remindAfter, err = time.ParseDuration(c.Reminders.After)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reminder duration: %s", err)
+		}
+		remindInterval = remindAfter
+	}
+	if c.Reminders.Interval != "" {
+		remindInterval, err = time.ParseDuration(c.Reminders.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse reminder interval: %s", err)
+		}
+	}
+	var configPoll time.Duration
+	if c.ConfigPoll != "" {
+		configPoll, err = time.ParseDuration(c.ConfigPoll)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config poll interval: %s", err)
+		}
+	}
+	queueInterval := time.Minute
+	if c.MergeQueue.Interval != "" {
+		queueInterval, err = time.ParseDuration(c.MergeQueue.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse merge queue interval: %s", err)
+		}
+	}
+	if c.MergeQueue.AutoBranch != "" && len(c.MergeQueue.RequiredContexts) == 0 {
+		return nil, fmt.Errorf("merge-queue.auto-branch requires merge-queue.required-contexts")
+	}
+	if c.MergeQueue.Method != "" {
+		if _, present := mergeMethods[c.MergeQueue.Method]; !present {
+			return nil, fmt.Errorf("unknown merge method '%s'", c.MergeQueue.Method)
+		}
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "logfmt"
+	}
+	if _, present := logFormats[c.Log.Format]; !present {
+		return nil, fmt.Errorf("unknown log format '%s'", c.Log.Format)
+	}
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	level, present := logLevels[c.Log.Level]
+	if !present {
+		return nil, fmt.Errorf("unknown log level '%s'", c.Log.Level)
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.AccessToken})
+	tc := oauth2.NewClient(oauth2.NoContext, ts)
+
+	return &rplus{
+		requiredReviews:    c.RequiredReviews,
+		reviewers:          reviewerMap,
+		groups:             groups,
+		labelRules:         c.Labels,
+		reviewPattern:      reviewPattern,
+		botName:            c.BotName,
+		selfReview:         c.SelfReview,
+		countEdited:        c.CountEdited,
+		botComments:        c.BotComment,
+		assign:             c.Assign,
+		remindAfter:        remindAfter,
+		remindInterval:     remindInterval,
+		digestIssue:        c.Reminders.DigestIssue,
+		digestHour:         c.Reminders.DigestHour,
+		queue:              c.MergeQueue,
+		queueInterval:      queueInterval,
+		admin:              c.Admin,
+		dashboard:          c.Dashboard,
+		templates:          templates,
+		checkCollaborators: c.CheckCollaborators,
+		collaboratorTTL:    collaboratorTTL,
+		overriders:         overriders,
+		overrideIssues:     c.OverrideIssues,
+		auditLog:           c.AuditLog,
+		carryApprovals:     c.CarryApprovals,
+		minOpen:            minOpen,
+		skipWeekends:       c.SkipWeekends,
+		repo:               c.Repo,
+		secret:             []byte(c.WebhookServer.Secret),
+		stateFile:          c.StateFile,
+		conf:               c,
+		configPoll:         configPoll,
+		loaded:             time.Now(),
+		pending:            make(map[int]*pull),
+		stop:               make(chan struct{}),
+		client:             tc,
+		log:                newLogger(os.Stderr, c.Log.Format, level).with("repo", c.Repo),
+	}, nil
+}
+
 func main() {
 	configPath := flag.String("config", "config.yml", "Path to configuration file")
 	flag.Parse()
 
-	contents, err := ioutil.ReadFile(*configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read config file '%s': %s\n", *configPath, err)
+	if flag.Arg(0) == "check-config" {
+		err := checkConfig(*configPath, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
-	var c config
-	err = yaml.Unmarshal(contents, &c)
+
+	c, err := loadConfig(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to parse config file '%s': %s\n", *configPath, err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	reviewerMap := make(map[string]struct{}, len(c.Reviewers))
-	for _, r := range c.Reviewers {
-		reviewerMap[r] = struct{}{}
+	if flag.Arg(0) == "admin" {
+		err = adminCLI(c, flag.Args()[1:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
-	reviewPattern, err := regexp.Compile(c.ReviewPattern)
+	rp, err := newRplus(c)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to compile review pattern: %s\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "Invalid config file '%s': %s\n", *configPath, err)
+		os.Exit(1)
 	}
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.AccessToken})
-	tc := oauth2.NewClient(oauth2.NoContext, ts)
-
-	rp := &rplus{
-		requiredReviews: c.RequiredReviews,
-		reviewers:       reviewerMap,
-		reviewPattern:   reviewPattern,
-		selfReview:      c.SelfReview,
-		repo:            c.Repo,
-		secret:          []byte(c.WebhookServer.Secret),
-		pending:         make(map[int]*pull),
-		client:          tc,
+	metricsPath := c.WebhookServer.MetricsPath
+	if metricsPath == "" {
+		metricsPath = "/metrics"
 	}
+	err = rp.loadState()
+	if err != nil {
+		rp.log.error("failed to load state file", "path", c.StateFile, "err", err)
+		os.Exit(1)
+	}
+	rp.goLoop(func() { rp.reloadLoop(*configPath) })
+	stopped := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		rp.log.info("shutting down", "signal", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopped <- rp.shutdown(ctx)
+	}()
 	err = rp.run(
 		c.WebhookServer.Addr,
 		c.WebhookServer.Cert,
 		c.WebhookServer.CertKey,
 		c.WebhookServer.PRPath,
 		c.WebhookServer.CommentPath,
+		metricsPath,
 	)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to run r-plus: %s\n", err)
+		rp.log.error("failed to run r-plus", "err", err)
+		os.Exit(1)
+	}
+	err = <-stopped
+	if err != nil {
+		rp.log.error("failed to shut down cleanly", "err", err)
+		os.Exit(1)
 	}
 }
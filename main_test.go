@@ -46,15 +46,15 @@ func TestMapMethods(t *testing.T) {
 		reviewers: map[string]struct{}{"rolandshoemaker": struct{}{}},
 	}
 
-	rp.newCommit(10, "hash", "roland")
+	rp.newCommit(nil, 10, "hash", "roland", "master", nil)
 	if rp.pending[10] == nil {
 		t.Fatal("newCommit didn't add entry")
 	}
 	if rp.pending[10].currentHash != "hash" {
 		t.Fatalf("newCommit added entry with incorrect hash: %s", rp.pending[10].currentHash)
 	}
-	if rp.pending[10].reviews != 0 {
-		t.Fatalf("newCommit added entry with non-zero reviews: %d", rp.pending[10].reviews)
+	if len(rp.pending[10].approvals) != 0 {
+		t.Fatalf("newCommit added entry with non-zero reviews: %d", len(rp.pending[10].approvals))
 	}
 	if ta.hits["/repos/testing/repo/statuses/hash"] == "" {
 		t.Fatal("newCommit didn't send pending status")
@@ -63,34 +63,34 @@ func TestMapMethods(t *testing.T) {
 		t.Fatalf("newCommit sent incorrect status: %s", ta.hits["hash"])
 	}
 
-	rp.newPlus(10, "rolandshoemaker")
+	rp.newPlus(nil, 10, "rolandshoemaker", "", 0)
 	if ta.hits["/repos/testing/repo/statuses/hash"] != "success" {
 		t.Fatalf("newPlus sent incorrect status: %s", ta.hits["hash"])
 	}
-	if rp.pending[10] != nil {
-		t.Fatal("newPlus should've removed pending pull after successful status was pushed")
+	if rp.pending[10] == nil || rp.pending[10].state != "success" {
+		t.Fatal("newPlus should've kept tracking pull after successful status was pushed")
 	}
 
 	rp.requiredReviews = 2
-	rp.newCommit(1, "other-hash", "roland")
+	rp.newCommit(nil, 1, "other-hash", "roland", "master", nil)
 	if rp.pending[1] == nil {
 		t.Fatal("newCommit didn't add entry")
 	}
 	if rp.pending[1].currentHash != "other-hash" {
 		t.Fatalf("newCommit added entry with incorrect hash: %s", rp.pending[1].currentHash)
 	}
-	rp.newPlus(1, "rolandshoemaker")
+	rp.newPlus(nil, 1, "rolandshoemaker", "", 0)
 	if rp.pending[1] == nil {
 		t.Fatal("newPlus removed an entry when it shouldn't have")
 	}
-	if rp.pending[1].reviews != 1 {
-		t.Fatalf("newPlus didn't increment number of reviews: %d", rp.pending[1].reviews)
+	if len(rp.pending[1].approvals) != 1 {
+		t.Fatalf("newPlus didn't increment number of reviews: %d", len(rp.pending[1].approvals))
 	}
 	if ta.hits["/repos/testing/repo/statuses/other-hash"] != "pending" {
 		t.Fatalf("newPlus change status when it shouldn't: %s", ta.hits["hash"])
 	}
 
-	rp.newPlus(12, "rolandshoemaker")
+	rp.newPlus(nil, 12, "rolandshoemaker", "", 0)
 	if rp.pending[12] != nil {
 		t.Fatal("newPlus acted on a nil pull")
 	}
@@ -99,7 +99,7 @@ func TestMapMethods(t *testing.T) {
 func TestVerifiedHandler(t *testing.T) {
 	rp := &rplus{secret: []byte("secret")}
 	success := false
-	h := rp.verifiedHandler(func(b []byte, w http.ResponseWriter) {
+	h := rp.verifiedHandler(func(l *logger, b []byte, w http.ResponseWriter) {
 		success = true
 	})
 	rec := httptest.NewRecorder()
@@ -151,15 +151,15 @@ func TestServer(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to marshal PullRequestEvent: %s", err)
 	}
-	rp.prHandler(body, rec)
+	rp.prHandler(nil, body, rec)
 	if rp.pending[1] == nil {
 		t.Fatal("entry wasn't added to pending map")
 	}
 	if rp.pending[1].currentHash != "hash" {
 		t.Fatalf("entry has incorrect hash: %s", rp.pending[1].currentHash)
 	}
-	if rp.pending[1].reviews != 0 {
-		t.Fatalf("entry has non-zero reviews: %d", rp.pending[1].reviews)
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("entry has non-zero reviews: %d", len(rp.pending[1].approvals))
 	}
 	if ta.hits["/repos/testing/repo/statuses/hash"] != "pending" {
 		t.Fatalf("incorrect status sent for entry: %s", ta.hits["hash"])
@@ -172,7 +172,7 @@ func TestServer(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to marshal PullRequestEvent: %s", err)
 	}
-	rp.prHandler(body, rec)
+	rp.prHandler(nil, body, rec)
 	if rp.pending[2] != nil {
 		t.Fatal("entry was added to pending map")
 	}
@@ -185,15 +185,15 @@ func TestServer(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to marshal PullRequestEvent: %s", err)
 	}
-	rp.prHandler(body, rec)
+	rp.prHandler(nil, body, rec)
 	if rp.pending[1] == nil {
 		t.Fatal("entry wasn't added to pending map")
 	}
 	if rp.pending[1].currentHash != "better-hash" {
 		t.Fatalf("entry has incorrect hash: %s", rp.pending[1].currentHash)
 	}
-	if rp.pending[1].reviews != 0 {
-		t.Fatalf("entry has non-zero reviews: %d", rp.pending[1].reviews)
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("entry has non-zero reviews: %d", len(rp.pending[1].approvals))
 	}
 	if ta.hits["/repos/testing/repo/statuses/better-hash"] != "pending" {
 		t.Fatalf("incorrect status sent for entry: %s", ta.hits["better-hash"])
@@ -212,11 +212,162 @@ func TestServer(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to marshal IssueCommentEvent: %s", err)
 	}
-	rp.commentHandler(body, rec)
+	rp.commentHandler(nil, body, rec)
 	if ta.hits["/repos/testing/repo/statuses/better-hash"] != "success" {
 		t.Fatalf("newPlus sent incorrect status: %s", ta.hits["hash"])
 	}
+	if rp.pending[1] == nil || rp.pending[1].state != "success" {
+		t.Fatal("newPlus should've kept tracking pull after successful status was pushed")
+	}
+
+	// closing the pull should stop tracking it
+	action = "closed"
+	body, err = json.Marshal(prEvent)
+	if err != nil {
+		t.Fatalf("Failed to marshal PullRequestEvent: %s", err)
+	}
+	rp.prHandler(nil, body, rec)
 	if rp.pending[1] != nil {
-		t.Fatal("newPlus should've removed pending pull after successful status was pushed")
+		t.Fatal("entry wasn't removed from pending map when pull was closed")
+	}
+}
+
+func TestApprovalForCommit(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	comments := []string{}
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal comment: %s", err)
+		}
+		comments = append(comments, *comment.Body)
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"rolandshoemaker": struct{}{}},
+		requiredReviews: 1,
+		reviewPattern:   regexp.MustCompile(`r\+(?:\s+(?P<sha>[0-9a-f]{7,40}))?`),
+	}
+	rp.newCommit(nil, 1, "abcdef0123456789", "roland", "master", nil)
+
+	rec := httptest.NewRecorder()
+	num := 1
+	reviewer := "rolandshoemaker"
+	commentBody := "r+ 1234567"
+	issueEvent := github.IssueCommentEvent{
+		Issue:   &github.Issue{PullRequestLinks: &github.PullRequestLinks{}, Number: &num},
+		Comment: &github.IssueComment{Body: &commentBody},
+		Sender:  &github.User{Login: &reviewer},
+	}
+	body, err := json.Marshal(issueEvent)
+	if err != nil {
+		t.Fatalf("Failed to marshal IssueCommentEvent: %s", err)
+	}
+	rp.commentHandler(nil, body, rec)
+	if len(rp.pending[1].approvals) != 0 {
+		t.Fatal("approval for stale commit was counted")
+	}
+	if len(comments) != 1 || !strings.Contains(comments[0], "abcdef0") {
+		t.Fatalf("no reply sent for approval of stale commit: %v", comments)
+	}
+
+	commentBody = "r+ abcdef0"
+	body, err = json.Marshal(issueEvent)
+	if err != nil {
+		t.Fatalf("Failed to marshal IssueCommentEvent: %s", err)
+	}
+	rp.commentHandler(nil, body, rec)
+	if ta.hits["/repos/testing/repo/statuses/abcdef0123456789"] != "success" {
+		t.Fatalf("approval for current commit wasn't counted: %s", ta.hits["/repos/testing/repo/statuses/abcdef0123456789"])
+	}
+}
+
+func TestCommentActions(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"rolandshoemaker": struct{}{}},
+		requiredReviews: 1,
+		reviewPattern:   regexp.MustCompile(`r\+`),
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+
+	rec := httptest.NewRecorder()
+	num, id := 1, 5
+	action, reviewer, sender := "created", "rolandshoemaker", "rolandshoemaker"
+	commentBody := "r+"
+	issueEvent := github.IssueCommentEvent{
+		Action:  &action,
+		Issue:   &github.Issue{PullRequestLinks: &github.PullRequestLinks{}, Number: &num},
+		Comment: &github.IssueComment{ID: &id, Body: &commentBody, User: &github.User{Login: &reviewer}},
+		Sender:  &github.User{Login: &sender},
+	}
+	send := func() {
+		body, err := json.Marshal(issueEvent)
+		if err != nil {
+			t.Fatalf("Failed to marshal IssueCommentEvent: %s", err)
+		}
+		rp.commentHandler(nil, body, rec)
+	}
+	statusPath := "/repos/testing/repo/statuses/hash"
+
+	send()
+	if ta.hits[statusPath] != "success" || rp.pending[1].approvals["rolandshoemaker"] != 5 {
+		t.Fatalf("approval from created comment wasn't counted: %s", ta.hits[statusPath])
+	}
+
+	// edits revoke the approval and aren't counted by default
+	action = "edited"
+	send()
+	if ta.hits[statusPath] != "pending" || len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("approval wasn't revoked by edit: %s", ta.hits[statusPath])
+	}
+
+	action = "created"
+	send()
+	action = "deleted"
+	send()
+	if ta.hits[statusPath] != "pending" || len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("approval wasn't revoked by deletion: %s", ta.hits[statusPath])
+	}
+
+	// deleting an unrelated comment shouldn't affect approvals
+	action = "created"
+	send()
+	id = 6
+	action = "deleted"
+	send()
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("approval was revoked by unrelated comment: %s", ta.hits[statusPath])
+	}
+
+	// when counting edits only the comment author's edits count
+	rp.countEdited = true
+	id = 5
+	action = "edited"
+	sender = "mallory"
+	send()
+	if ta.hits[statusPath] != "pending" || len(rp.pending[1].approvals) != 0 {
+		t.Fatalf("edit by another user was counted: %s", ta.hits[statusPath])
+	}
+	sender = "rolandshoemaker"
+	send()
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("edit by comment author wasn't counted: %s", ta.hits[statusPath])
 	}
 }
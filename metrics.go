@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// counter is a Prometheus counter with a fixed set of label names.
+type counter struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // keyed by the formatted label set
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc increments the counter with the given label values, which must be in
+// the same order as the label names.
+func (c *counter) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelSet(c.labels, values)]++
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, k, c.values[k])
+	}
+}
+
+// histogram is a Prometheus histogram without labels.
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64 // cumulative count for each bucket
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets ...float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelSet formats label names and values in the Prometheus text format,
+// e.g. {state="success",outcome="ok"}.
+func labelSet(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	webhooksReceived = newCounter("rplus_webhooks_received_total", "Webhooks received by path.", "path")
+	webhooksRejected = newCounter("rplus_webhooks_rejected_total", "Webhooks rejected by reason.", "reason")
+	statusesPosted   = newCounter("rplus_statuses_posted_total", "Statuses posted to GitHub by state and outcome.", "state", "outcome")
+	approvalsTotal   = newCounter("rplus_approvals_total", "Approvals accepted by reviewer.", "reviewer")
+	githubLatency    = newHistogram(
+		"rplus_github_request_duration_seconds",
+		"Latency of requests posting statuses to GitHub.",
+		.05, .1, .25, .5, 1, 2.5, 5, 10,
+	)
+)
+
+// metricsHandler serves the metrics in the Prometheus text format.
+func (rp *rplus) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	webhooksReceived.write(w)
+	webhooksRejected.write(w)
+	statusesPosted.write(w)
+	approvalsTotal.write(w)
+	githubLatency.write(w)
+
+	rp.stMu.Lock()
+	pending := rp.tracked
+	rp.stMu.Unlock()
+	fmt.Fprint(w, "# HELP rplus_pending_pulls Pull requests being tracked.\n# TYPE rplus_pending_pulls gauge\n")
+	fmt.Fprintf(w, "rplus_pending_pulls%s %d\n", labelSet([]string{"repo"}, []string{rp.repo}), pending)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabelSet(t *testing.T) {
+	if s := labelSet(nil, nil); s != "" {
+		t.Fatalf("labelSet returned %q for no labels", s)
+	}
+	s := labelSet([]string{"a", "b"}, []string{"x\"y", "z\\\n"})
+	if s != `{a="x\"y",b="z\\\n"}` {
+		t.Fatalf("labelSet returned incorrectly escaped labels: %s", s)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram("test_seconds", "Test.", 1, 5)
+	h.observe(0.5)
+	h.observe(2)
+	h.observe(10)
+	buf := new(bytes.Buffer)
+	h.write(buf)
+	for _, line := range []string{
+		`test_seconds_bucket{le="1"} 1`,
+		`test_seconds_bucket{le="5"} 2`,
+		`test_seconds_bucket{le="+Inf"} 3`,
+		`test_seconds_sum 12.5`,
+		`test_seconds_count 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("histogram output is missing %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func counterValue(c *counter, values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelSet(c.labels, values)]
+}
+
+func histogramCount(h *histogram) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"metrics-reviewer": struct{}{}},
+		requiredReviews: 1,
+		secret:          []byte("secret"),
+	}
+	// the counters are global, so compare their values before and after
+	// rather than assuming they start at zero
+	type sample struct {
+		c      *counter
+		values []string
+		delta  float64
+	}
+	samples := []sample{
+		{webhooksReceived, []string{"/metrics-test"}, 2},
+		{webhooksRejected, []string{"bad-method"}, 1},
+		{webhooksRejected, []string{"bad-signature"}, 1},
+		{statusesPosted, []string{"success", "ok"}, 1},
+		{approvalsTotal, []string{"metrics-reviewer"}, 1},
+	}
+	before := make([]float64, len(samples))
+	for i, s := range samples {
+		before[i] = counterValue(s.c, s.values...)
+	}
+	requests := histogramCount(githubLatency)
+
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "metrics-reviewer", "", 0)
+	h := rp.verifiedHandler(func(l *logger, b []byte, w http.ResponseWriter) {})
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics-test", nil))
+	req := httptest.NewRequest("POST", "/metrics-test", strings.NewReader("body"))
+	req.Header.Set("X-Hub-Signature", "sha1=00")
+	h(httptest.NewRecorder(), req)
+
+	// metrics don't wait for pulls being processed
+	rec := httptest.NewRecorder()
+	rp.pMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		rp.metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("metrics waited for rp.pMu")
+	}
+	rp.pMu.Unlock()
+	for i, s := range samples {
+		if d := counterValue(s.c, s.values...) - before[i]; d != s.delta {
+			t.Fatalf("%s%s increased by %g, expected %g", s.c.name, labelSet(s.c.labels, s.values), d, s.delta)
+		}
+	}
+	if histogramCount(githubLatency) == requests {
+		t.Fatal("GitHub request latency wasn't observed")
+	}
+	for _, line := range []string{
+		`rplus_webhooks_received_total{path="/metrics-test"} `,
+		`rplus_webhooks_rejected_total{reason="bad-method"} `,
+		`rplus_webhooks_rejected_total{reason="bad-signature"} `,
+		`rplus_statuses_posted_total{state="success",outcome="ok"} `,
+		`rplus_approvals_total{reviewer="metrics-reviewer"} `,
+		`rplus_github_request_duration_seconds_count `,
+		`rplus_pending_pulls{repo="testing/repo"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Fatalf("metrics are missing %q:\n%s", line, rec.Body.String())
+		}
+	}
+}
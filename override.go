@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// auditEntry is a single line of the audit log, recording an override
+// or, if Action is set, a manual change made through the admin API.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Repo   string    `json:"repo"`
+	PR     int       `json:"pr"`
+	Commit string    `json:"commit"`
+	User   string    `json:"user"`
+	Reason string    `json:"reason"`
+	Action string    `json:"action,omitempty"`
+	State  string    `json:"state,omitempty"`
+}
+
+// audit appends an entry to the audit log. The log is only ever opened
+// for appending so existing entries can't be rewritten.
+func (rp *rplus) audit(entry auditEntry) error {
+	if rp.auditLog == "" {
+		return fmt.Errorf("no audit log configured")
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rp.auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newOverride bypasses the review policy for the current head of a pull.
+// Overrides are only accepted from rp.overriders, must have a reason, and
+// are refused if they can't be recorded in the audit log.
+func (rp *rplus) newOverride(l *logger, pr int, user, reason string) {
+	if _, present := rp.overriders[user]; !present {
+		l.warn("ignoring override from non-overrider", "pr", pr, "user", user)
+		return
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		l.warn("received override on unknown pull", "pr", pr)
+		return
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		err := rp.comment(pr, fmt.Sprintf("@%s overrides require a reason, e.g. `r+ override: fixing outage`.", user))
+		if err != nil {
+			l.error("failed to comment", "pr", pr, "err", err)
+		}
+		return
+	}
+	err := rp.audit(auditEntry{
+		Time:   rp.now().UTC(),
+		Repo:   rp.repo,
+		PR:     pr,
+		Commit: o.currentHash,
+		User:   user,
+		Reason: reason,
+	})
+	if err != nil {
+		l.error("refusing override, failed to write audit log", "pr", pr, "err", err)
+		return
+	}
+	o.overrideBy = user
+	o.overrideReason = reason
+	err = rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+	if rp.overrideIssues {
+		err = rp.followUpIssue(pr, o.currentHash, user, reason)
+		if err != nil {
+			l.error("failed to create follow-up issue for override", "pr", pr, "err", err)
+		}
+	}
+}
+
+// followUpIssue opens an issue asking for a retroactive review of an
+// overridden pull.
+func (rp *rplus) followUpIssue(pr int, hash, user, reason string) error {
+	owner, repo := rp.ownerRepo()
+	title := fmt.Sprintf("Retroactive review of #%d", pr)
+	body := fmt.Sprintf(
+		"The review policy for #%d was overridden by @%s at commit %s.\n\nReason: %s\n\nPlease review the changes and close this issue once done.",
+		pr,
+		user,
+		hash,
+		reason,
+	)
+	_, _, err := rp.gh().Issues.Create(owner, repo, &github.IssueRequest{Title: &title, Body: &body})
+	return err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestOverride(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	comments, issues := 0, []github.IssueRequest{}
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		comments++
+	})
+	mux.HandleFunc("/repos/testing/repo/issues", func(w http.ResponseWriter, r *http.Request) {
+		var issue github.IssueRequest
+		err := json.NewDecoder(r.Body).Decode(&issue)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal issue: %s", err)
+		}
+		issues = append(issues, issue)
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"rolandshoemaker": struct{}{}},
+		requiredReviews: 2,
+		overriders:      map[string]struct{}{"alice": struct{}{}},
+		overrideIssues:  true,
+		auditLog:        filepath.Join(dir, "missing", "audit.log"),
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+
+	rp.newOverride(nil, 1, "rolandshoemaker", "because")
+	if rp.pending[1].overrideBy != "" {
+		t.Fatal("override accepted from user who isn't an overrider")
+	}
+	rp.newOverride(nil, 1, "alice", "  ")
+	if rp.pending[1].overrideBy != "" {
+		t.Fatal("override accepted without a reason")
+	}
+	if comments != 1 {
+		t.Fatalf("no reply sent for override without a reason: %d", comments)
+	}
+	rp.newOverride(nil, 1, "alice", "site is down")
+	if rp.pending[1].overrideBy != "" {
+		t.Fatal("override accepted when audit log couldn't be written")
+	}
+
+	rp.auditLog = filepath.Join(dir, "audit.log")
+	rp.newOverride(nil, 1, "alice", " site is down")
+	if ta.hits["/repos/testing/repo/statuses/hash"] != "success" {
+		t.Fatalf("override didn't send success status: %s", ta.hits["/repos/testing/repo/statuses/hash"])
+	}
+	if rp.pending[1].desc != "OVERRIDE by alice: site is down" {
+		t.Fatalf("override sent incorrect description: %s", rp.pending[1].desc)
+	}
+	log, err := ioutil.ReadFile(rp.auditLog)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("audit log has incorrect number of entries: %d", len(lines))
+	}
+	var entry auditEntry
+	err = json.Unmarshal([]byte(lines[0]), &entry)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal audit entry: %s", err)
+	}
+	if entry.PR != 1 || entry.User != "alice" || entry.Reason != "site is down" || entry.Commit != "hash" {
+		t.Fatalf("audit entry has incorrect fields: %#v", entry)
+	}
+	if len(issues) != 1 || !strings.Contains(*issues[0].Title, "#1") {
+		t.Fatalf("follow-up issue wasn't created: %v", issues)
+	}
+
+	// overrides only apply to the commit they were made on
+	rp.newCommit(nil, 1, "other-hash", "roland", "master", nil)
+	if ta.hits["/repos/testing/repo/statuses/other-hash"] != "pending" {
+		t.Fatalf("override applied to new commit: %s", ta.hits["/repos/testing/repo/statuses/other-hash"])
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// labelRule describes how the presence of a label on a pull request
+// modifies the review policy.
+type labelRule struct {
+	RequiredReviews int      `yaml:"required-reviews" json:"required-reviews"`
+	RequireGroups   []string `yaml:"require-groups" json:"require-groups"`
+	Block           bool     `yaml:"block" json:"block"`
+}
+
+// canReview returns true if the user is allowed to approve pull requests,
+// either by being listed as a reviewer or by being a member of a group.
+func (rp *rplus) canReview(user string) bool {
+	if _, present := rp.reviewers[user]; present {
+		return true
+	}
+	for _, members := range rp.groups {
+		if _, present := members[user]; present {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate works out which status the current head of a pull request
+// should have given its approvals, labels, and how long it has been open.
+func (rp *rplus) evaluate(o *pull) (string, string) {
+	state, desc := rp.assess(o)
+	if state == "success" && o.overrideBy == "" {
+		if at, waiting := rp.waiting(o); waiting {
+			return "pending", fmt.Sprintf("approved, waiting until %s", at.UTC().Format("Jan 2 15:04 UTC"))
+		}
+	}
+	return state, desc
+}
+
+// requirements works out the number of reviews required for a pull and
+// which of the groups required by its labels haven't approved it yet, or
+// the label blocking it if there is one.
+func (rp *rplus) requirements(o *pull) (int, []string, string) {
+	labels := make([]string, 0, len(o.labels))
+	for l := range o.labels {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	required := rp.requiredReviews
+	override := 0
+	groups := make(map[string]struct{})
+	for _, l := range labels {
+		rule, present := rp.labelRules[l]
+		if !present {
+			continue
+		}
+		if rule.Block {
+			return 0, nil, l
+		}
+		if rule.RequiredReviews > override {
+			override = rule.RequiredReviews
+		}
+		for _, g := range rule.RequireGroups {
+			groups[g] = struct{}{}
+		}
+	}
+	if override > 0 {
+		required = override
+	}
+	if required < 1 {
+		required = 1
+	}
+
+	missing := []string{}
+	for g := range groups {
+		satisfied := false
+		for reviewer := range o.approvals {
+			if _, present := rp.groups[g][reviewer]; present {
+				satisfied = true
+				break
+			}
+		}
+		if !satisfied {
+			missing = append(missing, g)
+		}
+	}
+	sort.Strings(missing)
+	return required, missing, ""
+}
+
+// assess works out which status the current head of a pull request should
+// have given its approvals and labels alone.
+func (rp *rplus) assess(o *pull) (string, string) {
+	if o.overrideBy != "" {
+		return "success", fmt.Sprintf("OVERRIDE by %s: %s", o.overrideBy, o.overrideReason)
+	}
+	required, missing, blockedBy := rp.requirements(o)
+	if blockedBy != "" {
+		return "failure", fmt.Sprintf("blocked by label '%s'", blockedBy)
+	}
+
+	state, desc := "success", fmt.Sprintf("approved with %d reviews", len(o.approvals))
+	if len(o.approvals) < required {
+		state, desc = "pending", fmt.Sprintf("%d of %d required reviews", len(o.approvals), required)
+	} else if len(missing) > 0 {
+		state, desc = "pending", fmt.Sprintf("waiting on review from %s", strings.Join(missing, ", "))
+	} else if o.carriedFrom != "" {
+		desc += fmt.Sprintf(", approvals carried from %s", shortHash(o.carriedFrom))
+	}
+	if len(o.delegates) > 0 {
+		desc += fmt.Sprintf(", delegated to %s", strings.Join(sortedKeys(o.delegates), ", "))
+	}
+	return state, desc
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// reevaluate posts the status for the current head of a pull request if it
+// has changed since it was last posted. rp.pMu must be held by the caller.
+func (rp *rplus) reevaluate(l *logger, pr int, o *pull) error {
+	state, desc := rp.evaluate(o)
+	if at, waiting := rp.waiting(o); waiting {
+		rp.schedule(pr, at)
+	}
+	if state == o.state && desc == o.desc {
+		return nil
+	}
+	err := rp.updateStatus(l, pr, o.currentHash, state, desc)
+	if err != nil {
+		return err
+	}
+	o.state, o.desc = state, desc
+	if rp.botComments {
+		rp.updateBotComment(l, pr, o)
+	}
+	return nil
+}
+
+func (rp *rplus) newLabels(l *logger, pr int, labels []string) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		l.warn("received labels on unknown pull", "pr", pr)
+		return
+	}
+	o.labels = make(map[string]struct{}, len(labels))
+	for _, l := range labels {
+		o.labels[l] = struct{}{}
+	}
+	err := rp.reevaluate(l, pr, o)
+	if err != nil {
+		l.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+	}
+	rp.saveState()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLabelRules(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		requiredReviews: 2,
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		groups: map[string]map[string]struct{}{
+			"security": {"carol": struct{}{}},
+		},
+		labelRules: map[string]labelRule{
+			"needs-security-review": {RequireGroups: []string{"security"}},
+			"trivial":               {RequiredReviews: 1},
+			"do-not-merge":          {Block: true},
+		},
+	}
+	statusPath := "/repos/testing/repo/statuses/hash"
+
+	rp.newCommit(nil, 1, "hash", "dave", "master", nil)
+	rp.newLabels(nil, 1, []string{"do-not-merge"})
+	if ta.hits[statusPath] != "failure" {
+		t.Fatalf("do-not-merge label didn't force failure status: %s", ta.hits[statusPath])
+	}
+	rp.newPlus(nil, 1, "alice", "", 0)
+	rp.newPlus(nil, 1, "bob", "", 0)
+	if ta.hits[statusPath] != "failure" {
+		t.Fatalf("approvals overrode do-not-merge label: %s", ta.hits[statusPath])
+	}
+	rp.newLabels(nil, 1, nil)
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("removing do-not-merge label didn't re-evaluate status: %s", ta.hits[statusPath])
+	}
+
+	rp.newLabels(nil, 1, []string{"needs-security-review"})
+	if ta.hits[statusPath] != "pending" {
+		t.Fatalf("needs-security-review label didn't require security group: %s", ta.hits[statusPath])
+	}
+	rp.newPlus(nil, 1, "carol", "", 0)
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("security group review wasn't counted: %s", ta.hits[statusPath])
+	}
+
+	rp.newCommit(nil, 1, "hash", "dave", "master", nil)
+	if _, present := rp.pending[1].labels["needs-security-review"]; !present {
+		t.Fatal("newCommit dropped labels from previous commit")
+	}
+	rp.newLabels(nil, 1, []string{"trivial"})
+	rp.newPlus(nil, 1, "alice", "", 0)
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("trivial label didn't reduce required reviews: %s", ta.hits[statusPath])
+	}
+	rp.newLabels(nil, 1, []string{"trivial", "needs-security-review"})
+	if ta.hits[statusPath] != "pending" {
+		t.Fatalf("needs-security-review label ignored alongside trivial: %s", ta.hits[statusPath])
+	}
+
+	rp.newLabels(nil, 2, []string{"trivial"})
+	if rp.pending[2] != nil {
+		t.Fatal("newLabels acted on a nil pull")
+	}
+}
+
+func TestLabelsFromPayload(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		requiredReviews: 1,
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		labelRules:      map[string]labelRule{"do-not-merge": {Block: true}},
+	}
+	statusPath := "/repos/testing/repo/statuses/hash"
+	event := func(action string) []byte {
+		return []byte(`{"action": "` + action + `", "number": 1, "pull_request": {"head": {"sha": "hash"}, "user": {"login": "dave"}, "labels": [{"name": "do-not-merge"}]}}`)
+	}
+
+	// labels are picked up from the pull when it's reopened, not just from
+	// labeled events
+	rp.prHandler(nil, event("opened"), httptest.NewRecorder())
+	rp.prHandler(nil, event("closed"), httptest.NewRecorder())
+	rp.prHandler(nil, event("reopened"), httptest.NewRecorder())
+	rp.newPlus(nil, 1, "alice", "", 0)
+	if ta.hits[statusPath] != "failure" {
+		t.Fatalf("reopened pull lost its labels: %s", ta.hits[statusPath])
+	}
+}
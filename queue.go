@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// queueConfig describes the merge queue which merges approved pulls once
+// their other statuses have passed.
+type queueConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Method is the merge method, one of "merge", "squash", or "rebase".
+	Method string `yaml:"method" json:"method"`
+	// RequiredContexts lists the status contexts which must be successful
+	// before merging, if empty every status other than r-plus' own must
+	// be successful.
+	RequiredContexts []string `yaml:"required-contexts" json:"required-contexts"`
+	// AutoBranch is the branch approved pulls are merged into for testing
+	// before their base branch is fast-forwarded to the result, if empty
+	// pulls are merged directly once their own statuses have passed.
+	AutoBranch string `yaml:"auto-branch" json:"auto-branch"`
+	// Interval is how often the queue is processed, defaulting to a minute.
+	Interval string `yaml:"interval" json:"interval"`
+}
+
+var mergeMethods = map[string]struct{}{
+	"merge":  struct{}{},
+	"squash": struct{}{},
+	"rebase": struct{}{},
+}
+
+// priorityArg returns the priority set by a "p=N" argument, if any.
+func priorityArg(args []string) (int, bool) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "p=") {
+			if p, err := strconv.Atoi(arg[2:]); err == nil {
+				return p, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// markRollup marks a pull as safe to test and merge together with other
+// rollups.
+func (rp *rplus) markRollup(l *logger, pr int, reviewer string) {
+	if !rp.isReviewer(l, reviewer) {
+		return
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		return
+	}
+	o.rollup = true
+	rp.saveState()
+}
+
+// prioritize sets the merge queue priority of a pull, pulls with higher
+// priorities are merged first.
+func (rp *rplus) prioritize(l *logger, pr int, reviewer string, priority int) {
+	if !rp.isReviewer(l, reviewer) {
+		return
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	o, present := rp.pending[pr]
+	if !present {
+		return
+	}
+	o.priority = priority
+	rp.saveState()
+}
+
+// ciState works out the combined state of the statuses other than r-plus'
+// own on a ref. If rp.queue.RequiredContexts is set only those contexts
+// are considered and missing ones are treated as pending, otherwise a ref
+// with no other statuses is pending.
+func (rp *rplus) ciState(ref string) (string, error) {
+	owner, repo := rp.ownerRepo()
+	combined, _, err := rp.gh().Repositories.GetCombinedStatus(owner, repo, ref, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return "", err
+	}
+	states := make(map[string]string)
+	for _, s := range combined.Statuses {
+		if s.Context == nil || s.State == nil || *s.Context == statusCtx {
+			continue
+		}
+		states[*s.Context] = *s.State
+	}
+	if len(rp.queue.RequiredContexts) > 0 {
+		required := make(map[string]string, len(rp.queue.RequiredContexts))
+		for _, c := range rp.queue.RequiredContexts {
+			required[c] = "pending"
+			if s, present := states[c]; present {
+				required[c] = s
+			}
+		}
+		states = required
+	}
+	if len(states) == 0 {
+		// nothing has reported on the ref yet
+		return "pending", nil
+	}
+	state := "success"
+	for _, s := range states {
+		switch s {
+		case "failure", "error":
+			return "failure", nil
+		case "pending":
+			state = "pending"
+		}
+	}
+	return state, nil
+}
+
+// mergePull merges a pull using the configured merge method, only if its
+// head is still hash.
+func (rp *rplus) mergePull(pr int, hash string) error {
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	body := struct {
+		SHA         string `json:"sha"`
+		MergeMethod string `json:"merge_method,omitempty"`
+	}{hash, rp.queue.Method}
+	req, err := client.NewRequest("PUT", fmt.Sprintf("repos/%s/%s/pulls/%d/merge", owner, repo, pr), body)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(req, nil)
+	return err
+}
+
+// queued returns the approved pulls waiting to be merged grouped by base
+// branch, in the order they should be merged. rp.pMu must be held by the
+// caller.
+func (rp *rplus) queued() map[string][]int {
+	queues := make(map[string][]int)
+	for pr, o := range rp.pending {
+		if o.state != "success" || o.mergeFailed == o.currentHash {
+			continue
+		}
+		queues[o.base] = append(queues[o.base], pr)
+	}
+	for _, prs := range queues {
+		sort.Sort(byPriority{prs, rp.pending})
+	}
+	return queues
+}
+
+type byPriority struct {
+	prs     []int
+	pending map[int]*pull
+}
+
+func (b byPriority) Len() int      { return len(b.prs) }
+func (b byPriority) Swap(i, j int) { b.prs[i], b.prs[j] = b.prs[j], b.prs[i] }
+func (b byPriority) Less(i, j int) bool {
+	pi, pj := b.pending[b.prs[i]].priority, b.pending[b.prs[j]].priority
+	if pi != pj {
+		return pi > pj
+	}
+	return b.prs[i] < b.prs[j]
+}
+
+// processQueue attempts to merge the first pull in the queue of each base
+// branch. Pulls whose statuses are still pending block the rest of their
+// queue so that at most one merge per branch happens at a time, while
+// pulls with failed statuses are skipped.
+func (rp *rplus) processQueue() {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	queues := rp.queued()
+	bases := make([]string, 0, len(queues))
+	for base := range queues {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		for _, pr := range queues[base] {
+			o := rp.pending[pr]
+			state, err := rp.ciState(o.currentHash)
+			if err != nil {
+				rp.log.error("failed to get statuses", "pr", pr, "sha", o.currentHash, "err", err)
+				break
+			}
+			if state == "failure" {
+				continue
+			}
+			if state == "pending" {
+				break
+			}
+			err = rp.mergePull(pr, o.currentHash)
+			if err != nil {
+				rp.log.error("failed to merge", "pr", pr, "sha", o.currentHash, "err", err)
+				o.mergeFailed = o.currentHash
+				rp.saveState()
+				cErr := rp.comment(pr, fmt.Sprintf("Failed to merge %s: %s", shortHash(o.currentHash), err))
+				if cErr != nil {
+					rp.log.error("failed to comment", "pr", pr, "err", cErr)
+				}
+				continue
+			}
+			delete(rp.pending, pr)
+			rp.saveState()
+			break
+		}
+	}
+}
+
+// queueLoop periodically processes the merge queue until rp.stop is
+// closed.
+func (rp *rplus) queueLoop(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rp.stop:
+			return
+		case <-ticker.C:
+		}
+		rp.confMu.RLock()
+		if rp.queue.AutoBranch != "" {
+			rp.processTestMerges()
+		} else {
+			rp.processQueue()
+		}
+		rp.confMu.RUnlock()
+	}
+}
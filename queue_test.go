@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestPriorityArg(t *testing.T) {
+	if p, present := priorityArg([]string{"abc1234", "p=10"}); !present || p != 10 {
+		t.Fatalf("priorityArg returned %d, %t", p, present)
+	}
+	if _, present := priorityArg([]string{"p=high"}); present {
+		t.Fatal("priorityArg accepted invalid priority")
+	}
+}
+
+func TestProcessQueue(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	ci := map[string]string{}
+	merged := []string{}
+	comments := make(map[string][]string)
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/commits/", func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/testing/repo/commits/"), "/status")
+		statuses := []github.RepoStatus{
+			{Context: github.String(statusCtx), State: github.String("success")},
+		}
+		if s, present := ci[hash]; present {
+			statuses = append(statuses, github.RepoStatus{Context: github.String("ci"), State: github.String(s)})
+		}
+		json.NewEncoder(w).Encode(github.CombinedStatus{Statuses: statuses})
+	})
+	mux.HandleFunc("/repos/testing/repo/pulls/", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			SHA         string `json:"sha"`
+			MergeMethod string `json:"merge_method"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal merge request: %s", err)
+		}
+		if r.Method != "PUT" || body.MergeMethod != "squash" {
+			t.Fatalf("Unexpected merge request: %s %+v", r.Method, body)
+		}
+		if body.SHA == "conflict-hash" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprint(w, `{"message": "Pull Request is not mergeable"}`)
+			return
+		}
+		merged = append(merged, r.URL.Path)
+	})
+	mux.HandleFunc("/repos/testing/repo/issues/", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal comment: %s", err)
+		}
+		comments[r.URL.Path] = append(comments[r.URL.Path], *comment.Body)
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		botName:         "rplus",
+		queue:           queueConfig{Enabled: true, Method: "squash"},
+	}
+	rp.newCommit(nil, 1, "hash-1", "roland", "master", nil)
+	rp.newCommit(nil, 2, "hash-2", "roland", "master", nil)
+	rp.newCommit(nil, 3, "hash-3", "roland", "master", nil)
+	rp.newCommit(nil, 4, "conflict-hash", "roland", "release", nil)
+	rp.newCommit(nil, 5, "hash-5", "roland", "release", nil)
+	rp.runCommands(commandContext{1, "alice", 1, nil}, "@rplus r+")
+	rp.runCommands(commandContext{2, "alice", 2, nil}, "@rplus r+ p=10")
+	rp.runCommands(commandContext{4, "alice", 4, nil}, "@rplus r+")
+	rp.runCommands(commandContext{5, "alice", 5, nil}, "@rplus r+")
+	if rp.pending[2].priority != 10 {
+		t.Fatalf("r+ p=10 set incorrect priority: %d", rp.pending[2].priority)
+	}
+	ci["hash-1"] = "success"
+	ci["hash-2"] = "pending"
+	ci["conflict-hash"] = "success"
+
+	// #5 can't be merged until CI has reported on it
+	rp.processQueue()
+	if len(merged) != 0 {
+		t.Fatalf("processQueue merged pulls without CI statuses: %v", merged)
+	}
+	rp.pending[4].mergeFailed = ""
+	delete(comments, "/repos/testing/repo/issues/4/comments")
+	ci["hash-5"] = "success"
+
+	// #2 has the highest priority and its pending CI blocks master, #3 isn't
+	// approved, and #4 fails to merge so #5 is merged instead
+	rp.processQueue()
+	if len(merged) != 1 || merged[0] != "/repos/testing/repo/pulls/5/merge" {
+		t.Fatalf("processQueue merged incorrect pulls: %v", merged)
+	}
+	if rp.pending[4].mergeFailed != "conflict-hash" {
+		t.Fatal("processQueue didn't record merge failure")
+	}
+	if c := comments["/repos/testing/repo/issues/4/comments"]; len(c) != 1 || !strings.Contains(c[0], "not mergeable") {
+		t.Fatalf("processQueue didn't comment about merge failure: %v", c)
+	}
+	if _, present := rp.pending[5]; present {
+		t.Fatal("processQueue didn't stop tracking merged pull")
+	}
+
+	// failed CI is skipped, and pulls which failed to merge aren't retried
+	// until they are updated
+	ci["hash-2"] = "failure"
+	rp.processQueue()
+	if len(merged) != 2 || merged[1] != "/repos/testing/repo/pulls/1/merge" {
+		t.Fatalf("processQueue merged incorrect pulls: %v", merged)
+	}
+	if len(comments["/repos/testing/repo/issues/4/comments"]) != 1 {
+		t.Fatal("processQueue retried pull which failed to merge")
+	}
+
+	// only the required contexts are considered
+	rp.queue.RequiredContexts = []string{"ci"}
+	ci["hash-2"] = "success"
+	rp.pending[3].state = "success"
+	rp.processQueue()
+	if len(merged) != 3 || merged[2] != "/repos/testing/repo/pulls/2/merge" {
+		t.Fatalf("processQueue merged incorrect pulls: %v", merged)
+	}
+	rp.processQueue()
+	if len(merged) != 3 {
+		t.Fatalf("processQueue merged pull without required contexts: %v", merged)
+	}
+}
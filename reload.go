@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// keepRestartOnly resets the settings in c which only take effect when
+// r-plus is restarted to their values in old, returning the keys of those
+// which were changed.
+func keepRestartOnly(old config, c *config) []string {
+	// secrets can be rotated, but enabling or disabling the admin API
+	// requires a restart
+	old.AccessToken = c.AccessToken
+	old.WebhookServer.Secret = c.WebhookServer.Secret
+	if (old.Admin.Token == "") == (c.Admin.Token == "") {
+		old.Admin.Token = c.Admin.Token
+	}
+	changed := []string{}
+	keep := func(key string, o, n interface{}) {
+		ov, nv := reflect.ValueOf(o).Elem(), reflect.ValueOf(n).Elem()
+		if !reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+			changed = append(changed, key)
+			nv.Set(ov)
+		}
+	}
+	keep("repo", &old.Repo, &c.Repo)
+	keep("state-file", &old.StateFile, &c.StateFile)
+	keep("webhook-server", &old.WebhookServer, &c.WebhookServer)
+	keep("admin", &old.Admin, &c.Admin)
+	keep("dashboard.path", &old.Dashboard.Path, &c.Dashboard.Path)
+	keep("log", &old.Log, &c.Log)
+	keep("merge-queue.enabled", &old.MergeQueue.Enabled, &c.MergeQueue.Enabled)
+	keep("merge-queue.interval", &old.MergeQueue.Interval, &c.MergeQueue.Interval)
+	keep("config-poll-interval", &old.ConfigPoll, &c.ConfigPoll)
+	return changed
+}
+
+// applyPolicy replaces the reloadable settings of rp with those of n.
+// rp.confMu and rp.pMu must be held by the caller.
+func (rp *rplus) applyPolicy(n *rplus) {
+	rp.requiredReviews = n.requiredReviews
+	rp.reviewers = n.reviewers
+	rp.groups = n.groups
+	rp.labelRules = n.labelRules
+	rp.reviewPattern = n.reviewPattern
+	rp.botName = n.botName
+	rp.selfReview = n.selfReview
+	rp.countEdited = n.countEdited
+	rp.botComments = n.botComments
+	rp.assign = n.assign
+	rp.remindAfter = n.remindAfter
+	rp.remindInterval = n.remindInterval
+	rp.digestIssue = n.digestIssue
+	rp.digestHour = n.digestHour
+	rp.queue = n.queue
+	rp.dashboard = n.dashboard
+	rp.templates = n.templates
+	rp.checkCollaborators = n.checkCollaborators
+	rp.collaboratorTTL = n.collaboratorTTL
+	rp.overriders = n.overriders
+	rp.overrideIssues = n.overrideIssues
+	rp.auditLog = n.auditLog
+	rp.carryApprovals = n.carryApprovals
+	rp.minOpen = n.minOpen
+	rp.skipWeekends = n.skipWeekends
+	rp.admin.Token = n.admin.Token
+	rp.secret = n.secret
+	rp.client = n.client
+	rp.conf = n.conf
+	rp.loaded = n.loaded
+}
+
+// pruneApprovals removes the approvals, and delegations, of users who
+// can't review under the current policy, returning the removed approvers.
+// Approvals can't be checked against push access without GitHub requests,
+// so they're kept if anyone with push access can review. rp.pMu must be
+// held by the caller.
+func (rp *rplus) pruneApprovals(o *pull) []string {
+	if rp.checkCollaborators && len(rp.reviewers) == 0 && len(rp.groups) == 0 {
+		return nil
+	}
+	for user, by := range o.delegates {
+		if !rp.canReview(by) {
+			delete(o.delegates, user)
+		}
+	}
+	removed := []string{}
+	for reviewer := range o.approvals {
+		if _, delegated := o.delegates[reviewer]; delegated || rp.canReview(reviewer) {
+			continue
+		}
+		delete(o.approvals, reviewer)
+		removed = append(removed, reviewer)
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// reload reads the configuration file again and, if it's valid, replaces
+// the current policy with it and re-evaluates every tracked pull, posting
+// new statuses for those whose evaluation changed. Tracked state is kept,
+// except for approvals from users the new policy doesn't allow to review.
+func (rp *rplus) reload(path string) error {
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	rp.confMu.Lock()
+	defer rp.confMu.Unlock()
+	for _, key := range keepRestartOnly(rp.conf, &c) {
+		rp.log.warn("setting can't be changed without a restart", "key", key)
+	}
+	n, err := newRplus(c)
+	if err != nil {
+		return err
+	}
+
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	rp.applyPolicy(n)
+	changed := 0
+	for _, pr := range rp.sortedPulls() {
+		o := rp.pending[pr]
+		state, desc := o.state, o.desc
+		if removed := rp.pruneApprovals(o); len(removed) > 0 {
+			rp.log.info("removed approvals from users who can no longer review", "pr", pr, "reviewers", strings.Join(removed, ","))
+		}
+		err := rp.reevaluate(rp.log, pr, o)
+		if err != nil {
+			rp.log.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+			continue
+		}
+		if o.state != state || o.desc != desc {
+			changed++
+		}
+	}
+	rp.saveState()
+	rp.log.info("reloaded config", "path", path, "changed-statuses", changed)
+	return nil
+}
+
+// modTime returns the modification time of a file, or the zero time if it
+// can't be read.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// reloadLoop reloads the configuration file on SIGHUP and, if
+// rp.configPoll is set, whenever its modification time changes, until
+// rp.stop is closed.
+func (rp *rplus) reloadLoop(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var poll <-chan time.Time
+	if rp.configPoll > 0 {
+		ticker := time.NewTicker(rp.configPoll)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	modified := modTime(path)
+	for {
+		select {
+		case <-rp.stop:
+			return
+		case <-hup:
+		case <-poll:
+			if modTime(path).Equal(modified) {
+				continue
+			}
+		}
+		modified = modTime(path)
+		err := rp.reload(path)
+		if err != nil {
+			rp.log.error("failed to reload config", "path", path, "err", err)
+		}
+	}
+}
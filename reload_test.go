@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.yml")
+	write := func(contents string) {
+		contents += "webhook-server:\n  pr-path: /pr\n  comment-path: /comment\n  secret: shhhh\n"
+		err := ioutil.WriteFile(configPath, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write config file: %s", err)
+		}
+	}
+
+	write("repo: testing/repo\nreviewers: [alice, bob]\nrequired-reviews: 2\n")
+	c, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+	rp, err := newRplus(c)
+	if err != nil {
+		t.Fatalf("Failed to create rplus: %s", err)
+	}
+	rp.client = new(http.Client)
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "alice", "", 0)
+	statusPath := "/repos/testing/repo/statuses/hash"
+	if ta.hits[statusPath] != "pending" {
+		t.Fatalf("pull has incorrect status: %s", ta.hits[statusPath])
+	}
+
+	// an invalid config is rejected and the current policy kept
+	write("repo: testing/repo\nreviewers: [alice]\nrequired-reviews: 1\nassign:\n  strategy: bogus\n")
+	if err := rp.reload(configPath); err == nil {
+		t.Fatal("reload accepted an invalid config")
+	}
+	if rp.requiredReviews != 2 || len(rp.reviewers) != 2 {
+		t.Fatal("reload of invalid config changed the policy")
+	}
+
+	// a valid config replaces the policy, keeping tracked state and
+	// settings which require a restart
+	write("repo: other/repo\nreviewers: [alice]\nrequired-reviews: 1\n")
+	if err := rp.reload(configPath); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if rp.requiredReviews != 1 || len(rp.reviewers) != 1 {
+		t.Fatal("reload didn't change the policy")
+	}
+	if rp.repo != "testing/repo" || rp.conf.Repo != "testing/repo" {
+		t.Fatal("reload changed the repository")
+	}
+	if _, present := rp.pending[1].approvals["alice"]; !present {
+		t.Fatal("reload lost tracked approvals")
+	}
+	if ta.hits[statusPath] != "success" {
+		t.Fatalf("reload didn't re-evaluate pull, status: %s", ta.hits[statusPath])
+	}
+
+	// approvals from users who are no longer reviewers aren't counted
+	write("repo: testing/repo\nreviewers: [bob]\nrequired-reviews: 1\n")
+	if err := rp.reload(configPath); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if _, present := rp.pending[1].approvals["alice"]; present {
+		t.Fatal("reload kept approval from removed reviewer")
+	}
+	if ta.hits[statusPath] != "pending" {
+		t.Fatalf("reload counted approval from removed reviewer, status: %s", ta.hits[statusPath])
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remindConfig describes when reminders about pulls waiting for review
+// are posted.
+type remindConfig struct {
+	// After is how long the current head of a pull must have been waiting
+	// for review before a reminder is posted.
+	After string `yaml:"after"`
+	// Interval is the minimum time between reminders on the same pull,
+	// defaulting to After.
+	Interval string `yaml:"interval"`
+	// DigestIssue is the number of an issue on which a daily digest of
+	// waiting pulls per reviewer is posted, if zero no digest is posted.
+	DigestIssue int `yaml:"digest-issue"`
+	// DigestHour is the hour of the day (UTC) the digest is posted at.
+	DigestHour int `yaml:"digest-hour"`
+}
+
+// needsReview returns true if the current head of a pull is still waiting
+// for approvals, as opposed to being approved or blocked.
+func (rp *rplus) needsReview(o *pull) bool {
+	state, _ := rp.assess(o)
+	return state == "pending"
+}
+
+// outstanding returns the users assigned to a pull who haven't approved
+// it yet.
+func outstanding(o *pull) []string {
+	users := []string{}
+	seen := make(map[string]struct{})
+	for _, u := range o.assigned {
+		if _, approved := o.approvals[u]; approved {
+			continue
+		}
+		if _, present := seen[u]; !present {
+			seen[u] = struct{}{}
+			users = append(users, u)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// remind posts a reminder on each pull whose current head has been
+// waiting for review for longer than rp.remindAfter, at most once every
+// rp.remindInterval, and posts the daily digest if it's due.
+func (rp *rplus) remind() {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	now := rp.now()
+	prs := make([]int, 0, len(rp.pending))
+	for pr := range rp.pending {
+		prs = append(prs, pr)
+	}
+	sort.Ints(prs)
+	changed := false
+	for _, pr := range prs {
+		o := rp.pending[pr]
+		if rp.remindAfter <= 0 || !rp.needsReview(o) || now.Sub(o.pushed) < rp.remindAfter {
+			continue
+		}
+		if !o.reminded.IsZero() && now.Sub(o.reminded) < rp.remindInterval {
+			continue
+		}
+		msg := fmt.Sprintf("This pull request has been waiting for review since %s.", o.pushed.UTC().Format("Jan 2 15:04 UTC"))
+		if users := outstanding(o); len(users) > 0 {
+			msg = fmt.Sprintf("@%s: %s", strings.Join(users, ", @"), msg)
+		}
+		err := rp.comment(pr, msg)
+		if err != nil {
+			rp.log.error("failed to post reminder", "pr", pr, "err", err)
+			continue
+		}
+		o.reminded = now
+		changed = true
+	}
+	if changed {
+		rp.saveState()
+	}
+
+	if rp.digestIssue != 0 && now.UTC().Hour() >= rp.digestHour && !sameDay(rp.lastDigest, now) {
+		err := rp.digest(prs)
+		if err != nil {
+			rp.log.error("failed to post digest", "issue", rp.digestIssue, "err", err)
+			return
+		}
+		rp.lastDigest = now
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	a, b = a.UTC(), b.UTC()
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// digest posts a comment on rp.digestIssue listing the pulls waiting for
+// review by each reviewer. rp.pMu must be held by the caller.
+func (rp *rplus) digest(prs []int) error {
+	waiting := make(map[string][]string)
+	for _, pr := range prs {
+		o := rp.pending[pr]
+		if !rp.needsReview(o) {
+			continue
+		}
+		for _, u := range outstanding(o) {
+			waiting[u] = append(waiting[u], fmt.Sprintf("#%d", pr))
+		}
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+	reviewers := make([]string, 0, len(waiting))
+	for u := range waiting {
+		reviewers = append(reviewers, u)
+	}
+	sort.Strings(reviewers)
+	lines := []string{"Pull requests waiting for review:", ""}
+	for _, u := range reviewers {
+		lines = append(lines, fmt.Sprintf("* @%s: %s", u, strings.Join(waiting[u], ", ")))
+	}
+	return rp.comment(rp.digestIssue, strings.Join(lines, "\n"))
+}
+
+// remindLoop periodically calls remind until rp.stop is closed.
+func (rp *rplus) remindLoop(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rp.stop:
+			return
+		case <-ticker.C:
+		}
+		rp.confMu.RLock()
+		rp.remind()
+		rp.confMu.RUnlock()
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestRemind(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	comments := make(map[string][]string)
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/issues/", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal comment: %s", err)
+		}
+		comments[r.URL.Path] = append(comments[r.URL.Path], *comment.Body)
+	})
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	now := time.Date(2016, time.April, 1, 6, 0, 0, 0, time.UTC)
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		requiredReviews: 1,
+		remindAfter:     24 * time.Hour,
+		remindInterval:  12 * time.Hour,
+		digestIssue:     100,
+		digestHour:      9,
+		clock:           func() time.Time { return now },
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.pending[1].assigned = []string{"alice", "bob"}
+	rp.newCommit(nil, 2, "other-hash", "roland", "master", nil)
+	rp.pending[2].assigned = []string{"bob"}
+	rp.newCommit(nil, 3, "approved-hash", "roland", "master", nil)
+	rp.pending[3].assigned = []string{"alice"}
+	rp.newPlus(nil, 3, "alice", "", 0)
+
+	remindPath := "/repos/testing/repo/issues/1/comments"
+	digestPath := "/repos/testing/repo/issues/100/comments"
+
+	now = now.Add(2 * time.Hour)
+	rp.remind()
+	if len(comments) != 0 {
+		t.Fatalf("reminders posted before pulls were stale: %v", comments)
+	}
+
+	now = now.Add(25 * time.Hour)
+	rp.newCommit(nil, 2, "newer-hash", "roland", "master", nil)
+	rp.remind()
+	if len(comments[remindPath]) != 1 || !strings.HasPrefix(comments[remindPath][0], "@alice, @bob: ") {
+		t.Fatalf("reminder wasn't posted mentioning outstanding reviewers: %v", comments[remindPath])
+	}
+	if len(comments["/repos/testing/repo/issues/2/comments"]) != 0 {
+		t.Fatal("reminder posted on pull with a new commit")
+	}
+	if len(comments["/repos/testing/repo/issues/3/comments"]) != 0 {
+		t.Fatal("reminder posted on approved pull")
+	}
+	if len(comments[digestPath]) != 1 {
+		t.Fatalf("digest wasn't posted: %v", comments[digestPath])
+	}
+	if !strings.Contains(comments[digestPath][0], "* @alice: #1\n* @bob: #1, #2") {
+		t.Fatalf("digest has incorrect contents: %s", comments[digestPath][0])
+	}
+
+	// reminders and digests are rate limited
+	now = now.Add(time.Hour)
+	rp.newPlus(nil, 1, "bob", "", 0)
+	rp.newMinus(nil, 1, "bob")
+	rp.remind()
+	if len(comments[remindPath]) != 1 || len(comments[digestPath]) != 1 {
+		t.Fatalf("reminder or digest posted again too soon: %v", comments)
+	}
+
+	now = now.Add(12 * time.Hour)
+	rp.remind()
+	if len(comments[remindPath]) != 2 {
+		t.Fatalf("reminder wasn't posted again after interval: %v", comments[remindPath])
+	}
+	if len(comments[digestPath]) != 1 {
+		t.Fatalf("digest posted twice in one day: %v", comments[digestPath])
+	}
+
+	now = now.Add(24 * time.Hour)
+	rp.remind()
+	if len(comments[digestPath]) != 2 {
+		t.Fatalf("digest wasn't posted on the next day: %v", comments[digestPath])
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// resolveSecret returns the value of a secret setting, which is either
+// the secret itself, "env:NAME" to read it from an environment variable,
+// or "file:/path" to read it from a file, for instance a mounted
+// Kubernetes secret. Surrounding whitespace is trimmed from secrets read
+// from files.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, present := os.LookupEnv(name)
+		if !present {
+			return "", fmt.Errorf("environment variable %s isn't set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		contents, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(contents)), nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces the references in the secret settings of c with
+// their values, returning a problem for each which can't be resolved.
+func (c *config) resolveSecrets() []string {
+	problems := []string{}
+	for _, s := range []struct {
+		key   string
+		value *string
+	}{
+		{"access-token", &c.AccessToken},
+		{"webhook-server.secret", &c.WebhookServer.Secret},
+		{"admin.token", &c.Admin.Token},
+	} {
+		secret, err := resolveSecret(*s.value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed to resolve %s: %s", s.key, err))
+			continue
+		}
+		*s.value = secret
+	}
+	return problems
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	secretPath := filepath.Join(dir, "secret")
+	err = ioutil.WriteFile(secretPath, []byte("from-file\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write secret file: %s", err)
+	}
+	os.Setenv("RPLUS_TEST_SECRET", "from-env")
+	defer os.Unsetenv("RPLUS_TEST_SECRET")
+
+	for value, expected := range map[string]string{
+		"literal":               "literal",
+		"env:RPLUS_TEST_SECRET": "from-env",
+		"file:" + secretPath:    "from-file",
+		"":                      "",
+	} {
+		secret, err := resolveSecret(value)
+		if err != nil {
+			t.Fatalf("resolveSecret(%q) failed: %s", value, err)
+		}
+		if secret != expected {
+			t.Fatalf("resolveSecret(%q) returned %q, expected %q", value, secret, expected)
+		}
+	}
+	for _, value := range []string{"env:RPLUS_TEST_MISSING", "file:" + filepath.Join(dir, "missing")} {
+		if _, err := resolveSecret(value); err == nil {
+			t.Fatalf("resolveSecret(%q) didn't fail", value)
+		}
+	}
+}
+
+func TestRotateSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	secretPath := filepath.Join(dir, "secret")
+	configPath := filepath.Join(dir, "config.yml")
+	write := func(path, contents string) {
+		err := ioutil.WriteFile(path, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write %s: %s", path, err)
+		}
+	}
+	write(secretPath, "old-secret")
+	write(configPath, "repo: testing/repo\nwebhook-server:\n  pr-path: /pr\n  comment-path: /comment\n  secret: file:"+secretPath+"\n")
+
+	c, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+	rp, err := newRplus(c)
+	if err != nil {
+		t.Fatalf("Failed to create rplus: %s", err)
+	}
+	if string(rp.secret) != "old-secret" {
+		t.Fatalf("secret wasn't read from file: %q", rp.secret)
+	}
+
+	write(secretPath, "new-secret")
+	if err := rp.reload(configPath); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if string(rp.secret) != "new-secret" {
+		t.Fatalf("reload didn't re-read secret: %q", rp.secret)
+	}
+
+	os.Remove(secretPath)
+	if err := rp.reload(configPath); err == nil {
+		t.Fatal("reload accepted a missing secret file")
+	}
+	if string(rp.secret) != "new-secret" {
+		t.Fatalf("failed reload changed secret: %q", rp.secret)
+	}
+}
@@ -5,32 +5,45 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 
 	"github.com/google/go-github/github"
 )
 
-func (rp *rplus) verifiedHandler(handler func([]byte, http.ResponseWriter)) http.HandlerFunc {
+// webhookHandler handles the verified body of a webhook, logging with a
+// logger which includes the delivery ID.
+type webhookHandler func(l *logger, body []byte, w http.ResponseWriter)
+
+func (rp *rplus) verifiedHandler(handler webhookHandler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rp.confMu.RLock()
+		defer rp.confMu.RUnlock()
+		path := ""
+		if r.URL != nil {
+			path = r.URL.Path
+		}
+		l := rp.log.with("delivery", r.Header.Get("X-GitHub-Delivery"), "path", path)
+		webhooksReceived.inc(path)
 		if r.Method != "POST" {
-			fmt.Fprintf(os.Stderr, "Invalid request method: %s\n", r.Method)
+			l.warn("invalid request method", "method", r.Method)
+			webhooksRejected.inc("bad-method")
 			return
 		}
 
 		// Get signature
 		githubSignature := r.Header.Get("X-Hub-Signature")
 		if githubSignature == "" {
-			fmt.Fprintln(os.Stderr, "No signature on request")
+			l.warn("no signature on request")
+			webhooksRejected.inc("bad-signature")
 			return
 		}
 
 		// Read body
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading request body: %s\n", err)
+			l.error("failed to read request body", "err", err)
+			webhooksRejected.inc("parse-error")
 			return
 		}
 
@@ -39,49 +52,131 @@ func (rp *rplus) verifiedHandler(handler func([]byte, http.ResponseWriter)) http
 		mac.Write(body)
 		expectedMAC := mac.Sum(nil)
 		if len(githubSignature) < 5 {
-			fmt.Fprintln(os.Stderr, "Invalid signature on request, no actual signature")
+			l.warn("invalid signature on request, no actual signature")
+			webhooksRejected.inc("bad-signature")
 			return
 		}
 		sigBytes, err := hex.DecodeString(githubSignature[5:])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid signature on request: %s", err)
+			l.warn("invalid signature on request", "err", err)
+			webhooksRejected.inc("bad-signature")
 			return
 		}
 		if match := hmac.Equal(sigBytes, expectedMAC); !match {
-			fmt.Fprintf(os.Stderr, "Invalid signature on request, provided: %x, expected: sha1=%x\n", githubSignature, expectedMAC)
+			l.warn("invalid signature on request", "provided", githubSignature)
+			webhooksRejected.inc("bad-signature")
 			return
 		}
 
-		fmt.Fprintf(os.Stdout, "Request with valid signature for endpoint: %s\n", r.URL)
-		handler(body, w)
+		l.debug("request with valid signature")
+		rp.webhookReceived()
+		handler(l, body, w)
 	})
 }
 
-func (rp *rplus) prHandler(body []byte, w http.ResponseWriter) {
+// payloadLabels returns the names of the labels of the pull in a pull
+// request event, which github.PullRequest doesn't include.
+func payloadLabels(body []byte) ([]string, error) {
+	var labelEvent struct {
+		PullRequest struct {
+			Labels []github.Label `json:"labels"`
+		} `json:"pull_request"`
+	}
+	err := json.Unmarshal(body, &labelEvent)
+	if err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for _, l := range labelEvent.PullRequest.Labels {
+		if l.Name != nil {
+			labels = append(labels, *l.Name)
+		}
+	}
+	return labels, nil
+}
+
+func (rp *rplus) prHandler(l *logger, body []byte, w http.ResponseWriter) {
 	var event github.PullRequestEvent
 	err := json.Unmarshal(body, &event)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to unmarshal PR event: %s\n", err)
+		l.error("failed to unmarshal PR event", "err", err)
+		webhooksRejected.inc("parse-error")
 		return
 	}
-	if *event.Action != "opened" && *event.Action != "synchronize" {
-		return
+	sha := ""
+	if event.PullRequest.Head != nil && event.PullRequest.Head.SHA != nil {
+		sha = *event.PullRequest.Head.SHA
+	}
+	l.info("received pull request event", "pr", *event.Number, "sha", sha, "action", *event.Action)
+	switch *event.Action {
+	case "opened", "reopened", "synchronize":
+		labels, err := payloadLabels(body)
+		if err != nil {
+			l.error("failed to unmarshal PR labels", "err", err)
+			webhooksRejected.inc("parse-error")
+			return
+		}
+		base := ""
+		if event.PullRequest.Base != nil && event.PullRequest.Base.Ref != nil {
+			base = *event.PullRequest.Base.Ref
+		}
+		rp.newCommit(l, *event.Number, *event.PullRequest.Head.SHA, *event.PullRequest.User.Login, base, labels)
+		if *event.Action == "opened" && rp.assign.Strategy != "" {
+			rp.autoAssign(l, *event.Number, *event.PullRequest.User.Login, base)
+		}
+	case "labeled", "unlabeled":
+		labels, err := payloadLabels(body)
+		if err != nil {
+			l.error("failed to unmarshal PR labels", "err", err)
+			webhooksRejected.inc("parse-error")
+			return
+		}
+		rp.newLabels(l, *event.Number, labels)
+	case "closed":
+		rp.closed(l, *event.Number)
 	}
-	rp.newCommit(*event.Number, *event.PullRequest.Head.SHA, *event.PullRequest.User.Login)
 }
 
-func (rp *rplus) commentHandler(body []byte, w http.ResponseWriter) {
+func (rp *rplus) commentHandler(l *logger, body []byte, w http.ResponseWriter) {
 	var event github.IssueCommentEvent
 	err := json.Unmarshal(body, &event)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to unmarshal comment event: %s\n", err)
+		l.error("failed to unmarshal comment event", "err", err)
+		webhooksRejected.inc("parse-error")
 		return
 	}
 	if event.Issue.PullRequestLinks == nil {
 		return
 	}
-	if !rp.reviewPattern.Match([]byte(*event.Comment.Body)) {
+	action := "created"
+	if event.Action != nil {
+		action = *event.Action
+	}
+	commentID := 0
+	if event.Comment.ID != nil {
+		commentID = *event.Comment.ID
+	}
+	l.info("received comment event", "pr", *event.Issue.Number, "action", action, "comment", commentID)
+	switch action {
+	case "created":
+	case "edited":
+		// an edit revokes any approval made by the comment, if edits are
+		// counted and the comment was edited by its author it is then
+		// treated like a new comment
+		rp.revokeComment(l, *event.Issue.Number, commentID)
+		if !rp.countEdited || event.Comment.User == nil || *event.Comment.User.Login != *event.Sender.Login {
+			return
+		}
+	case "deleted":
+		rp.revokeComment(l, *event.Issue.Number, commentID)
+		return
+	default:
 		return
 	}
-	rp.newPlus(*event.Issue.Number, *event.Sender.Login)
+	rp.runCommands(commandContext{
+		pr:        *event.Issue.Number,
+		user:      *event.Sender.Login,
+		commentID: commentID,
+		log:       l,
+	}, *event.Comment.Body)
 }
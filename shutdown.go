@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is how long in-flight work is given to finish when
+// r-plus is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// goLoop runs a background loop, which must return once rp.stop is
+// closed, so that shutdown can wait for it.
+func (rp *rplus) goLoop(loop func()) {
+	rp.loops.Add(1)
+	go func() {
+		defer rp.loops.Done()
+		loop()
+	}()
+}
+
+// stopping returns true once shutdown has started.
+func (rp *rplus) stopping() bool {
+	select {
+	case <-rp.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// serve serves requests with srv until it's shut down, returning nil if
+// it was shut down or never started because shutdown had already begun.
+func (rp *rplus) serve(srv *http.Server, certPath, keyPath string) error {
+	rp.sMu.Lock()
+	if rp.stopping() {
+		rp.sMu.Unlock()
+		return nil
+	}
+	rp.servers = append(rp.servers, srv)
+	rp.sMu.Unlock()
+	var err error
+	if certPath != "" && keyPath != "" {
+		err = srv.ListenAndServeTLS(certPath, keyPath)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// wait waits for done to be closed or ctx to expire.
+func wait(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops accepting requests, waits for in-flight requests and
+// background loops to finish, cancels scheduled re-evaluations, and
+// flushes the state to disk. If ctx expires first the remaining work is
+// abandoned and its error returned.
+func (rp *rplus) shutdown(ctx context.Context) error {
+	rp.sMu.Lock()
+	if rp.stopping() {
+		rp.sMu.Unlock()
+		return nil
+	}
+	close(rp.stop)
+	servers := rp.servers
+	rp.sMu.Unlock()
+
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	loopsDone := make(chan struct{})
+	go func() {
+		rp.loops.Wait()
+		close(loopsDone)
+	}()
+	err := wait(ctx, loopsDone)
+	if err != nil {
+		return err
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		rp.pMu.Lock()
+		defer rp.pMu.Unlock()
+		for pr, t := range rp.timers {
+			t.Stop()
+			delete(rp.timers, pr)
+		}
+		rp.saveState()
+		close(flushed)
+	}()
+	err = wait(ctx, flushed)
+	if err != nil {
+		return err
+	}
+	rp.log.info("shut down")
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestShutdown(t *testing.T) {
+	received, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(received) })
+		<-release
+	}))
+	defer api.Close()
+	apiBase = api.URL
+
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	rp := &rplus{
+		pending:   make(map[int]*pull),
+		client:    new(http.Client),
+		repo:      "testing/repo",
+		secret:    []byte("secret"),
+		stateFile: filepath.Join(dir, "state.json"),
+		stop:      make(chan struct{}),
+	}
+	ran := make(chan error, 1)
+	go func() {
+		ran <- rp.run(addr, "", "", "/pr", "/comment", "/metrics")
+	}()
+
+	body, err := json.Marshal(github.PullRequestEvent{
+		Action: github.String("opened"),
+		Number: github.Int(1),
+		PullRequest: &github.PullRequest{
+			Head: &github.PullRequestBranch{SHA: github.String("hash")},
+			User: &github.User{Login: github.String("roland")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal event: %s", err)
+	}
+	mac := hmac.New(sha1.New, rp.secret)
+	mac.Write(body)
+	webhook := make(chan int, 1)
+	go func() {
+		for {
+			req, _ := http.NewRequest("POST", "http://"+addr+"/pr", bytes.NewReader(body))
+			req.Header.Set("X-Hub-Signature", fmt.Sprintf("sha1=%x", mac.Sum(nil)))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				// the server may not be listening yet
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			resp.Body.Close()
+			webhook <- resp.StatusCode
+			return
+		}
+	}()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook didn't post a status")
+	}
+
+	// the webhook is now blocked posting a status, so shutdown must wait
+	// for it
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- rp.shutdown(ctx)
+	}()
+	select {
+	case err := <-stopped:
+		t.Fatalf("shutdown returned with a webhook in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		t.Fatal("server accepted a request while shutting down")
+	}
+
+	close(release)
+	if code := <-webhook; code != http.StatusOK {
+		t.Fatalf("in-flight webhook returned %d", code)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+	if err := <-ran; err != nil {
+		t.Fatalf("run returned an error: %s", err)
+	}
+
+	loaded := &rplus{stateFile: rp.stateFile, pending: make(map[int]*pull), client: new(http.Client), repo: "testing/repo"}
+	if err := loaded.loadState(); err != nil {
+		t.Fatalf("Failed to load state: %s", err)
+	}
+	if o := loaded.pending[1]; o == nil || o.currentHash != "hash" {
+		t.Fatal("state wasn't flushed on shutdown")
+	}
+}
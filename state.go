@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pullState is the on-disk representation of a pull.
+type pullState struct {
+	CurrentHash    string            `json:"current-hash"`
+	Author         string            `json:"author"`
+	Base           string            `json:"base,omitempty"`
+	Approvals      map[string]int    `json:"approvals,omitempty"`
+	Labels         []string          `json:"labels,omitempty"`
+	Opened         time.Time         `json:"opened"`
+	Pushed         time.Time         `json:"pushed"`
+	Reminded       time.Time         `json:"reminded"`
+	Fingerprint    string            `json:"fingerprint,omitempty"`
+	CarriedFrom    string            `json:"carried-from,omitempty"`
+	OverrideBy     string            `json:"override-by,omitempty"`
+	OverrideReason string            `json:"override-reason,omitempty"`
+	State          string            `json:"state,omitempty"`
+	Desc           string            `json:"desc,omitempty"`
+	Priority       int               `json:"priority,omitempty"`
+	MergeFailed    string            `json:"merge-failed,omitempty"`
+	TestMerge      string            `json:"test-merge,omitempty"`
+	Rollup         bool              `json:"rollup,omitempty"`
+	Batch          int               `json:"batch,omitempty"`
+	Assigned       []string          `json:"assigned,omitempty"`
+	Delegates      map[string]string `json:"delegates,omitempty"`
+	BotComment     int               `json:"bot-comment,omitempty"`
+}
+
+func setToList(set map[string]struct{}) []string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	return list
+}
+
+func listToSet(list []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(list))
+	for _, k := range list {
+		set[k] = struct{}{}
+	}
+	return set
+}
+
+// saveState writes the tracked pulls to rp.stateFile so they survive
+// restarts, recording the outcome in rp.stateErr. rp.pMu must be held by
+// the caller.
+func (rp *rplus) saveState() {
+	rp.countTracked()
+	if rp.stateFile == "" {
+		return
+	}
+	err := rp.writeState()
+	rp.stMu.Lock()
+	rp.stateErr = err
+	rp.stMu.Unlock()
+	if err != nil {
+		rp.log.error("failed to write state file", "path", rp.stateFile, "err", err)
+	}
+}
+
+// countTracked records the number of tracked pulls in rp.tracked. rp.pMu
+// must be held by the caller.
+func (rp *rplus) countTracked() {
+	rp.stMu.Lock()
+	defer rp.stMu.Unlock()
+	rp.tracked = len(rp.pending)
+}
+
+// writeState writes the tracked pulls to rp.stateFile. rp.pMu must be held
+// by the caller.
+func (rp *rplus) writeState() error {
+	state := make(map[int]pullState, len(rp.pending))
+	for pr, o := range rp.pending {
+		state[pr] = pullState{
+			CurrentHash:    o.currentHash,
+			Author:         o.author,
+			Base:           o.base,
+			Approvals:      o.approvals,
+			Labels:         setToList(o.labels),
+			Opened:         o.opened,
+			Pushed:         o.pushed,
+			Reminded:       o.reminded,
+			Fingerprint:    o.fingerprint,
+			CarriedFrom:    o.carriedFrom,
+			OverrideBy:     o.overrideBy,
+			OverrideReason: o.overrideReason,
+			State:          o.state,
+			Desc:           o.desc,
+			Priority:       o.priority,
+			MergeFailed:    o.mergeFailed,
+			TestMerge:      o.testMerge,
+			Rollup:         o.rollup,
+			Batch:          o.batch,
+			Assigned:       o.assigned,
+			Delegates:      o.delegates,
+			BotComment:     o.botComment,
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so a crash mid-write doesn't
+	// leave a truncated state file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(rp.stateFile), filepath.Base(rp.stateFile))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), rp.stateFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// loadState reads the tracked pulls from rp.stateFile, if it exists, and
+// re-evaluates their statuses so that any waiting periods which elapsed
+// while r-plus wasn't running are handled.
+func (rp *rplus) loadState() error {
+	if rp.stateFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(rp.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state map[int]pullState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	for pr, s := range state {
+		if s.Approvals == nil {
+			s.Approvals = make(map[string]int)
+		}
+		o := &pull{
+			currentHash:    s.CurrentHash,
+			author:         s.Author,
+			base:           s.Base,
+			approvals:      s.Approvals,
+			labels:         listToSet(s.Labels),
+			opened:         s.Opened,
+			pushed:         s.Pushed,
+			reminded:       s.Reminded,
+			fingerprint:    s.Fingerprint,
+			carriedFrom:    s.CarriedFrom,
+			overrideBy:     s.OverrideBy,
+			overrideReason: s.OverrideReason,
+			state:          s.State,
+			desc:           s.Desc,
+			priority:       s.Priority,
+			mergeFailed:    s.MergeFailed,
+			testMerge:      s.TestMerge,
+			rollup:         s.Rollup,
+			batch:          s.Batch,
+			assigned:       s.Assigned,
+			delegates:      s.Delegates,
+			botComment:     s.BotComment,
+		}
+		rp.pending[pr] = o
+		err = rp.reevaluate(rp.log, pr, o)
+		if err != nil {
+			rp.log.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+		}
+	}
+	rp.countTracked()
+	return nil
+}
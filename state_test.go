@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	dir, err := ioutil.TempDir("", "r-plus")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		requiredReviews: 2,
+		stateFile:       stateFile,
+	}
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	rp.newLabels(nil, 1, []string{"bug"})
+	rp.newPlus(nil, 1, "alice", "", 0)
+
+	restarted := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}, "bob": struct{}{}},
+		requiredReviews: 2,
+		stateFile:       stateFile,
+	}
+	err = restarted.loadState()
+	if err != nil {
+		t.Fatalf("Failed to load state: %s", err)
+	}
+	o := restarted.pending[1]
+	if o == nil {
+		t.Fatal("pull wasn't restored from state file")
+	}
+	if o.currentHash != "hash" || o.author != "roland" || !o.opened.Equal(rp.pending[1].opened) {
+		t.Fatalf("pull restored with incorrect fields: %#v", o)
+	}
+	if _, present := o.approvals["alice"]; !present || len(o.approvals) != 1 {
+		t.Fatalf("pull restored with incorrect approvals: %v", o.approvals)
+	}
+	if _, present := o.labels["bug"]; !present {
+		t.Fatalf("pull restored with incorrect labels: %v", o.labels)
+	}
+
+	restarted.newPlus(nil, 1, "bob", "", 0)
+	if ta.hits["/repos/testing/repo/statuses/hash"] != "success" {
+		t.Fatalf("restored pull didn't accept further approvals: %s", ta.hits["/repos/testing/repo/statuses/hash"])
+	}
+
+	restarted.closed(nil, 1)
+	restarted.pending = make(map[int]*pull)
+	err = restarted.loadState()
+	if err != nil {
+		t.Fatalf("Failed to load state: %s", err)
+	}
+	if len(restarted.pending) != 0 {
+		t.Fatal("closed pull was restored from state file")
+	}
+}
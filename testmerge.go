@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/go-github/github"
+)
+
+// resetBranch points a branch at sha, creating it if it doesn't exist.
+func (rp *rplus) resetBranch(branch, sha string) error {
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}
+	_, _, err := client.Git.UpdateRef(owner, repo, ref, true)
+	if err == nil {
+		return nil
+	}
+	_, _, cErr := client.Git.CreateRef(owner, repo, ref)
+	if cErr != nil {
+		return err
+	}
+	return nil
+}
+
+// startTestMerge merges the heads of a batch of pulls into
+// rp.queue.AutoBranch, which is first reset to their base branch, so that
+// CI can test the result before the base branch is updated. Pulls which
+// conflict are reported as failed and left out of the batch. It returns
+// the pulls which were merged. rp.pMu must be held by the caller.
+func (rp *rplus) startTestMerge(prs []int) ([]int, error) {
+	owner, repo := rp.ownerRepo()
+	client := rp.gh()
+	baseBranch := rp.pending[prs[0]].base
+	base, _, err := client.Git.GetRef(owner, repo, "heads/"+baseBranch)
+	if err != nil {
+		return nil, err
+	}
+	if base.Object == nil || base.Object.SHA == nil {
+		return nil, fmt.Errorf("branch '%s' has no commit", baseBranch)
+	}
+	err = rp.resetBranch(rp.queue.AutoBranch, *base.Object.SHA)
+	if err != nil {
+		return nil, err
+	}
+	merged := []int{}
+	sha := ""
+	for _, pr := range prs {
+		o := rp.pending[pr]
+		commit, _, err := client.Repositories.Merge(owner, repo, &github.RepositoryMergeRequest{
+			Base:          github.String(rp.queue.AutoBranch),
+			Head:          github.String(o.currentHash),
+			CommitMessage: github.String(fmt.Sprintf("Auto merge of #%d - %s into %s", pr, shortHash(o.currentHash), o.base)),
+		})
+		if err == nil && (commit == nil || commit.SHA == nil) {
+			err = fmt.Errorf("%s is already merged into %s", shortHash(o.currentHash), o.base)
+		}
+		if err != nil {
+			rp.testMergeFailed(pr, o, err)
+			continue
+		}
+		merged = append(merged, pr)
+		sha = *commit.SHA
+	}
+	for _, pr := range merged {
+		rp.pending[pr].testMerge = sha
+	}
+	return merged, nil
+}
+
+// fastForward updates a branch to sha, failing if sha isn't a descendant
+// of the branch.
+func (rp *rplus) fastForward(branch, sha string) error {
+	owner, repo := rp.ownerRepo()
+	_, _, err := rp.gh().Git.UpdateRef(owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}, false)
+	return err
+}
+
+// baseMoved returns true if an error from updating a branch means it
+// couldn't be fast-forwarded, i.e. it was updated since the test merge.
+func baseMoved(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusUnprocessableEntity
+}
+
+// testMergeFailed records that the current head of a pull couldn't be
+// merged and reports why on the pull. rp.pMu must be held by the caller.
+func (rp *rplus) testMergeFailed(pr int, o *pull, err error) {
+	rp.log.error("failed to merge", "pr", pr, "sha", o.currentHash, "err", err)
+	o.testMerge = ""
+	o.mergeFailed = o.currentHash
+	rp.saveState()
+	cErr := rp.comment(pr, fmt.Sprintf("Failed to merge %s: %s", shortHash(o.currentHash), err))
+	if cErr != nil {
+		rp.log.error("failed to comment", "pr", pr, "err", cErr)
+	}
+}
+
+// abandonTestMerge stops testing a merge commit, for instance because one
+// of the pulls it includes was updated. rp.pMu must be held by the caller.
+func (rp *rplus) abandonTestMerge(sha string) {
+	for _, o := range rp.pending {
+		if o.testMerge == sha {
+			o.testMerge = ""
+		}
+	}
+}
+
+// testing returns the pulls included in the test merge in progress, if
+// any. rp.pMu must be held by the caller.
+func (rp *rplus) testing() []int {
+	sha := ""
+	for _, o := range rp.pending {
+		if o.testMerge != "" {
+			sha = o.testMerge
+			break
+		}
+	}
+	prs := []int{}
+	for pr, o := range rp.pending {
+		if sha != "" && o.testMerge == sha {
+			prs = append(prs, pr)
+		}
+	}
+	sort.Ints(prs)
+	return prs
+}
+
+// nextBatch returns the pulls which should be tested next: the first pull
+// in the queue along with, if it's a rollup, the rest of its batch or all
+// the other queued rollups on the same base which aren't in a batch yet.
+// rp.pMu must be held by the caller.
+func (rp *rplus) nextBatch() []int {
+	prs := []int{}
+	for _, queue := range rp.queued() {
+		prs = append(prs, queue...)
+	}
+	if len(prs) == 0 {
+		return nil
+	}
+	sort.Sort(byPriority{prs, rp.pending})
+	first := rp.pending[prs[0]]
+	if !first.rollup {
+		return prs[:1]
+	}
+	batch := []int{}
+	for _, pr := range prs {
+		o := rp.pending[pr]
+		if o.rollup && o.base == first.base && o.batch == first.batch {
+			batch = append(batch, pr)
+		}
+	}
+	if first.batch == 0 {
+		id := rp.newBatchID()
+		for _, pr := range batch {
+			rp.pending[pr].batch = id
+		}
+	}
+	return batch
+}
+
+// newBatchID returns an unused rollup batch ID. rp.pMu must be held by the
+// caller.
+func (rp *rplus) newBatchID() int {
+	id := 0
+	for _, o := range rp.pending {
+		if o.batch > id {
+			id = o.batch
+		}
+	}
+	return id + 1
+}
+
+// bisect splits a rollup batch whose test merge failed in two, so that
+// each half is tested separately until the pulls responsible are found.
+// rp.pMu must be held by the caller.
+func (rp *rplus) bisect(prs []int) {
+	sha := rp.pending[prs[0]].testMerge
+	half := (len(prs) + 1) / 2
+	id := rp.newBatchID()
+	for _, pr := range prs[:half] {
+		rp.pending[pr].batch = id
+	}
+	for _, pr := range prs[half:] {
+		rp.pending[pr].batch = id + 1
+	}
+	rp.abandonTestMerge(sha)
+	rp.saveState()
+	for _, pr := range prs {
+		err := rp.comment(pr, fmt.Sprintf("Statuses failed on rollup %s, testing smaller batches to find the cause.", shortHash(sha)))
+		if err != nil {
+			rp.log.error("failed to comment", "pr", pr, "err", err)
+		}
+	}
+}
+
+// processTestMerges advances the test merge in progress, if any, otherwise
+// starts a test merge of the next batch in the queue. Only a single test
+// merge happens at a time since they all share rp.queue.AutoBranch.
+func (rp *rplus) processTestMerges() {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	if prs := rp.testing(); len(prs) > 0 {
+		o := rp.pending[prs[0]]
+		sha := o.testMerge
+		state, err := rp.ciState(sha)
+		if err != nil {
+			rp.log.error("failed to get statuses for test merge", "sha", sha, "err", err)
+			return
+		}
+		switch state {
+		case "pending":
+			return
+		case "failure":
+			if len(prs) > 1 {
+				rp.bisect(prs)
+				return
+			}
+			rp.testMergeFailed(prs[0], o, fmt.Errorf("statuses failed on test merge %s", shortHash(sha)))
+			return
+		}
+		err = rp.fastForward(o.base, sha)
+		if baseMoved(err) {
+			// retest against the new base on the next pass
+			rp.log.info("base moved during test merge, retrying", "base", o.base, "sha", sha)
+			rp.abandonTestMerge(sha)
+			rp.saveState()
+			return
+		} else if err != nil {
+			for _, pr := range prs {
+				rp.testMergeFailed(pr, rp.pending[pr], err)
+			}
+			return
+		}
+		for _, pr := range prs {
+			delete(rp.pending, pr)
+		}
+		rp.saveState()
+		return
+	}
+
+	batch := rp.nextBatch()
+	if len(batch) == 0 {
+		return
+	}
+	merged, err := rp.startTestMerge(batch)
+	if err != nil {
+		for _, pr := range batch {
+			rp.testMergeFailed(pr, rp.pending[pr], err)
+		}
+		return
+	}
+	rp.saveState()
+	for _, pr := range merged {
+		o := rp.pending[pr]
+		msg := fmt.Sprintf("Testing merge of %s into %s as %s.", shortHash(o.currentHash), o.base, shortHash(o.testMerge))
+		if len(merged) > 1 {
+			msg = fmt.Sprintf("Testing merge of %s into %s as part of rollup %s with %d other pull requests.", shortHash(o.currentHash), o.base, shortHash(o.testMerge), len(merged)-1)
+		}
+		err = rp.comment(pr, msg)
+		if err != nil {
+			rp.log.error("failed to comment", "pr", pr, "err", err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// testMergeServer fakes the parts of the GitHub API used by test merges.
+// Test merge commits are named after the heads merged into the base, and
+// unless ci says otherwise their statuses fail if a head contains "bad".
+func testMergeServer(t *testing.T, ci, refs map[string]string, moved *bool, comments map[string][]string) *httptest.Server {
+	ta := &testAPI{make(map[string]string), t}
+	mux := http.NewServeMux()
+	mux.Handle("/repos/testing/repo/statuses/", ta)
+	mux.HandleFunc("/repos/testing/repo/commits/", func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/testing/repo/commits/"), "/status")
+		state, present := ci[hash]
+		if !present {
+			state = "success"
+			if strings.Contains(hash, "bad") {
+				state = "failure"
+			}
+		}
+		json.NewEncoder(w).Encode(github.CombinedStatus{Statuses: []github.RepoStatus{
+			{Context: github.String("ci"), State: github.String(state)},
+		}})
+	})
+	mux.HandleFunc("/repos/testing/repo/git/refs/", func(w http.ResponseWriter, r *http.Request) {
+		ref := strings.TrimPrefix(r.URL.Path, "/repos/testing/repo/git/refs/")
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(github.Reference{Ref: github.String("refs/" + ref), Object: &github.GitObject{SHA: github.String(refs[ref])}})
+		case "PATCH":
+			var body struct {
+				SHA   string `json:"sha"`
+				Force bool   `json:"force"`
+			}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				t.Fatalf("Failed to unmarshal ref update: %s", err)
+			}
+			if _, present := refs[ref]; !present {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprint(w, `{"message": "Reference does not exist"}`)
+				return
+			}
+			if ref == "heads/master" && (body.Force || *moved) {
+				*moved = false
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprint(w, `{"message": "Update is not a fast forward"}`)
+				return
+			}
+			refs[ref] = body.SHA
+			json.NewEncoder(w).Encode(github.Reference{Ref: github.String("refs/" + ref)})
+		}
+	})
+	mux.HandleFunc("/repos/testing/repo/git/refs", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal ref creation: %s", err)
+		}
+		refs[strings.TrimPrefix(body.Ref, "refs/")] = body.SHA
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(github.Reference{Ref: github.String(body.Ref)})
+	})
+	mux.HandleFunc("/repos/testing/repo/merges", func(w http.ResponseWriter, r *http.Request) {
+		var body github.RepositoryMergeRequest
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal merge request: %s", err)
+		}
+		if *body.Base != "auto" || !strings.HasPrefix(refs["heads/auto"], refs["heads/master"]) {
+			t.Fatalf("Test merge wasn't based on master: %s at %s", *body.Base, refs["heads/auto"])
+		}
+		if *body.Head == "conflict-hash" {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"message": "Merge conflict"}`)
+			return
+		}
+		refs["heads/auto"] += "+" + *body.Head
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(github.RepositoryCommit{SHA: github.String(refs["heads/auto"])})
+	})
+	mux.HandleFunc("/repos/testing/repo/issues/", func(w http.ResponseWriter, r *http.Request) {
+		var comment github.IssueComment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal comment: %s", err)
+		}
+		comments[r.URL.Path] = append(comments[r.URL.Path], *comment.Body)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestProcessTestMerges(t *testing.T) {
+	ci := map[string]string{"base-hash+hash-1": "pending"}
+	refs := map[string]string{"heads/master": "base-hash"}
+	moved := false
+	comments := make(map[string][]string)
+	serv := testMergeServer(t, ci, refs, &moved, comments)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		botName:         "rplus",
+		queue:           queueConfig{Enabled: true, AutoBranch: "auto", RequiredContexts: []string{"ci"}},
+	}
+	rp.newCommit(nil, 1, "hash-1", "roland", "master", nil)
+	rp.newCommit(nil, 2, "conflict-hash", "roland", "master", nil)
+	rp.newCommit(nil, 3, "hash-3", "roland", "master", nil)
+	rp.runCommands(commandContext{1, "alice", 1, nil}, "@rplus r+ p=1")
+	rp.runCommands(commandContext{2, "alice", 2, nil}, "@rplus r+ p=2")
+	rp.runCommands(commandContext{3, "alice", 3, nil}, "@rplus r+")
+
+	// the highest priority pull conflicts, so the auto branch is created
+	// but the pull is reported as failed
+	rp.processTestMerges()
+	if rp.pending[2].mergeFailed != "conflict-hash" {
+		t.Fatal("processTestMerges didn't record merge conflict")
+	}
+	if c := comments["/repos/testing/repo/issues/2/comments"]; len(c) != 1 || !strings.Contains(c[0], "Merge conflict") {
+		t.Fatalf("processTestMerges didn't comment about merge conflict: %v", c)
+	}
+
+	rp.processTestMerges()
+	if rp.pending[1].testMerge != "base-hash+hash-1" {
+		t.Fatalf("processTestMerges didn't start test merge: %q", rp.pending[1].testMerge)
+	}
+	if c := comments["/repos/testing/repo/issues/1/comments"]; len(c) != 1 || c[0] != "Testing merge of hash-1 into master as base-ha." {
+		t.Fatalf("processTestMerges didn't comment about test merge: %v", c)
+	}
+
+	// nothing happens while the test merge is pending, and another pull
+	// isn't started
+	rp.processTestMerges()
+	if refs["heads/master"] != "base-hash" || rp.pending[3].testMerge != "" {
+		t.Fatal("processTestMerges progressed while test merge was pending")
+	}
+
+	// if master moves the pull is retested
+	delete(ci, "base-hash+hash-1")
+	moved = true
+	rp.processTestMerges()
+	if rp.pending[1].testMerge != "" || rp.pending[1].mergeFailed != "" {
+		t.Fatal("processTestMerges didn't retry after base moved")
+	}
+	rp.processTestMerges()
+	rp.processTestMerges()
+	if refs["heads/master"] != "base-hash+hash-1" {
+		t.Fatalf("processTestMerges didn't fast-forward master: %s", refs["heads/master"])
+	}
+	if _, present := rp.pending[1]; present {
+		t.Fatal("processTestMerges didn't stop tracking merged pull")
+	}
+
+	// failed statuses on the test merge are reported
+	ci["base-hash+hash-1+hash-3"] = "failure"
+	rp.processTestMerges()
+	rp.processTestMerges()
+	if rp.pending[3].mergeFailed != "hash-3" || refs["heads/master"] != "base-hash+hash-1" {
+		t.Fatal("processTestMerges merged pull with failed statuses")
+	}
+	if c := comments["/repos/testing/repo/issues/3/comments"]; len(c) != 2 || !strings.Contains(c[1], "statuses failed") {
+		t.Fatalf("processTestMerges didn't comment about failed statuses: %v", c)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	refs := map[string]string{"heads/master": "base-hash", "heads/auto": "old-hash"}
+	moved := false
+	comments := make(map[string][]string)
+	serv := testMergeServer(t, map[string]string{}, refs, &moved, comments)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"alice": struct{}{}},
+		requiredReviews: 1,
+		botName:         "rplus",
+		queue:           queueConfig{Enabled: true, AutoBranch: "auto", RequiredContexts: []string{"ci"}},
+	}
+	for pr, hash := range []string{"hash-0", "hash-1", "hash-2", "bad-hash", "hash-4", "hash-5"} {
+		rp.newCommit(nil, pr, hash, "roland", "master", nil)
+	}
+	for pr := 1; pr <= 4; pr++ {
+		rp.runCommands(commandContext{pr, "alice", pr, nil}, "@rplus r+ rollup")
+	}
+	rp.runCommands(commandContext{5, "alice", 5, nil}, "@rplus r+")
+
+	rp.processTestMerges()
+	if prs := rp.testing(); len(prs) != 4 || rp.pending[1].testMerge != "base-hash+hash-1+hash-2+bad-hash+hash-4" {
+		t.Fatalf("processTestMerges didn't test rollup: %v", prs)
+	}
+	if c := comments["/repos/testing/repo/issues/1/comments"]; len(c) != 1 || !strings.Contains(c[0], "as part of rollup") {
+		t.Fatalf("processTestMerges didn't comment about rollup: %v", c)
+	}
+
+	// the failed rollup is bisected until the bad pull is found, while the
+	// others are merged
+	for i := 0; i < 9; i++ {
+		rp.processTestMerges()
+	}
+	if refs["heads/master"] != "base-hash+hash-1+hash-2+hash-4" {
+		t.Fatalf("processTestMerges merged incorrect pulls: %s", refs["heads/master"])
+	}
+	if len(rp.pending) != 3 || rp.pending[3].mergeFailed != "bad-hash" {
+		t.Fatal("processTestMerges didn't find the pull which failed the rollup")
+	}
+
+	// pulls which aren't rollups are tested on their own
+	rp.processTestMerges()
+	rp.processTestMerges()
+	if refs["heads/master"] != "base-hash+hash-1+hash-2+hash-4+hash-5" {
+		t.Fatalf("processTestMerges didn't merge pull: %s", refs["heads/master"])
+	}
+}
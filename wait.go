@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+)
+
+func (rp *rplus) now() time.Time {
+	if rp.clock != nil {
+		return rp.clock()
+	}
+	return time.Now()
+}
+
+// readyAt returns the time at which a pull opened at the given time has
+// been open for the minimum duration. If rp.skipWeekends is set time
+// spent on Saturdays and Sundays (in UTC) isn't counted.
+func (rp *rplus) readyAt(opened time.Time) time.Time {
+	if !rp.skipWeekends {
+		return opened.Add(rp.minOpen)
+	}
+	t := opened.UTC()
+	remaining := rp.minOpen
+	for remaining > 0 {
+		midnight := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+			t = midnight
+			continue
+		}
+		if left := midnight.Sub(t); left < remaining {
+			remaining -= left
+			t = midnight
+			continue
+		}
+		t = t.Add(remaining)
+		remaining = 0
+	}
+	return t
+}
+
+// waiting returns the time at which a pull will have been open for the
+// minimum duration and whether that time is still in the future.
+func (rp *rplus) waiting(o *pull) (time.Time, bool) {
+	if rp.minOpen <= 0 {
+		return time.Time{}, false
+	}
+	at := rp.readyAt(o.opened)
+	return at, rp.now().Before(at)
+}
+
+// schedule arranges for the status of a pull to be re-evaluated at the
+// given time, replacing any previously scheduled re-evaluation.
+// rp.pMu must be held by the caller.
+func (rp *rplus) schedule(pr int, at time.Time) {
+	if rp.timers == nil {
+		rp.timers = make(map[int]*time.Timer)
+	}
+	if t, present := rp.timers[pr]; present {
+		t.Stop()
+	}
+	rp.timers[pr] = time.AfterFunc(at.Sub(rp.now()), func() { rp.wake(pr) })
+}
+
+// wake re-evaluates the status of a pull whose waiting period may have
+// elapsed.
+func (rp *rplus) wake(pr int) {
+	rp.pMu.Lock()
+	defer rp.pMu.Unlock()
+	delete(rp.timers, pr)
+	o, present := rp.pending[pr]
+	if !present || rp.stopping() {
+		return
+	}
+	err := rp.reevaluate(rp.log, pr, o)
+	if err != nil {
+		rp.log.error("failed to update status", "pr", pr, "sha", o.currentHash, "err", err)
+		rp.schedule(pr, rp.now().Add(time.Minute))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyAt(t *testing.T) {
+	rp := &rplus{minOpen: 24 * time.Hour}
+	// Friday
+	opened := time.Date(2016, time.April, 1, 20, 0, 0, 0, time.UTC)
+	if at := rp.readyAt(opened); !at.Equal(time.Date(2016, time.April, 2, 20, 0, 0, 0, time.UTC)) {
+		t.Fatalf("readyAt returned incorrect time: %s", at)
+	}
+	rp.skipWeekends = true
+	if at := rp.readyAt(opened); !at.Equal(time.Date(2016, time.April, 4, 20, 0, 0, 0, time.UTC)) {
+		t.Fatalf("readyAt didn't skip weekend: %s", at)
+	}
+	// Saturday
+	opened = time.Date(2016, time.April, 2, 12, 0, 0, 0, time.UTC)
+	if at := rp.readyAt(opened); !at.Equal(time.Date(2016, time.April, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("readyAt didn't skip weekend: %s", at)
+	}
+}
+
+func TestMinOpenDuration(t *testing.T) {
+	ta := &testAPI{make(map[string]string), t}
+	serv := httptest.NewServer(ta)
+	defer serv.Close()
+	apiBase = serv.URL
+
+	now := time.Date(2016, time.April, 1, 12, 0, 0, 0, time.UTC)
+	rp := &rplus{
+		pending:         make(map[int]*pull),
+		client:          new(http.Client),
+		repo:            "testing/repo",
+		reviewers:       map[string]struct{}{"rolandshoemaker": struct{}{}},
+		requiredReviews: 1,
+		minOpen:         2 * time.Hour,
+		clock:           func() time.Time { return now },
+	}
+	defer func() {
+		for _, timer := range rp.timers {
+			timer.Stop()
+		}
+	}()
+
+	rp.newCommit(nil, 1, "hash", "roland", "master", nil)
+	now = now.Add(time.Hour)
+	rp.newPlus(nil, 1, "rolandshoemaker", "", 0)
+	if ta.hits["/repos/testing/repo/statuses/hash"] != "pending" {
+		t.Fatalf("approval didn't wait for minimum open duration: %s", ta.hits["/repos/testing/repo/statuses/hash"])
+	}
+	if !strings.Contains(rp.pending[1].desc, "waiting until Apr 1 14:00 UTC") {
+		t.Fatalf("status description doesn't mention waiting period: %s", rp.pending[1].desc)
+	}
+	if rp.timers[1] == nil {
+		t.Fatal("re-evaluation wasn't scheduled")
+	}
+
+	now = now.Add(time.Hour)
+	rp.wake(1)
+	if ta.hits["/repos/testing/repo/statuses/hash"] != "success" {
+		t.Fatalf("status wasn't updated after waiting period elapsed: %s", ta.hits["/repos/testing/repo/statuses/hash"])
+	}
+
+	// new commits shouldn't restart the waiting period
+	rp.newCommit(nil, 1, "other-hash", "roland", "master", nil)
+	rp.newPlus(nil, 1, "rolandshoemaker", "", 0)
+	if ta.hits["/repos/testing/repo/statuses/other-hash"] != "success" {
+		t.Fatalf("new commit restarted waiting period: %s", ta.hits["/repos/testing/repo/statuses/other-hash"])
+	}
+}